@@ -0,0 +1,78 @@
+package main
+
+import (
+	"arbitrage/transfer"
+	"arbitrage/utils"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+)
+
+// runAddresses lists the address book withdrawals are checked against, or
+// changes it. An address is approved for one exchange, coin and chain, with
+// the memo deposits on that chain need; a coin cannot be withdrawn before
+// its limits are set. The trader holds the database, so it must be stopped
+// first.
+//
+//	arbitrage addresses [-approve | -revoke] -exchange KUCOIN -coin XRP -chain XRP [-address a -memo m]
+//	arbitrage addresses -limit -coin XRP -per 500 -daily 2000
+func runAddresses(args []string) {
+	flags := flag.NewFlagSet("addresses", flag.ExitOnError)
+	approve := flags.Bool("approve", false, "approve -address and -memo as the deposit address of -coin on -chain at -exchange")
+	revoke := flags.Bool("revoke", false, "remove the address approved for -coin on -chain at -exchange")
+	limit := flags.Bool("limit", false, "set the withdrawal limits of -coin to -per and -daily")
+	exchange := flags.String("exchange", "", "exchange the address belongs to, BINANCE or KUCOIN")
+	coin := flags.String("coin", "", "coin")
+	chain := flags.String("chain", "", "network, as Binance names it or as KuCoin's chain ID")
+	address := flags.String("address", "", "deposit address")
+	memo := flags.String("memo", "", "memo or tag, required on memo chains")
+	per := flags.Float64("per", 0, "most of the coin one withdrawal may send")
+	daily := flags.Float64("daily", 0, "most of the coin withdrawn in a UTC day, across exchanges")
+	flags.Parse(args)
+
+	db, err := utils.Database("orderdb")
+	if err != nil {
+		log.Fatal("Failed to open LevelDB:", err)
+	}
+	defer db.Close()
+	transfer.UseAddressBook(db)
+
+	switch {
+	case *approve:
+		err := transfer.Approve(transfer.ApprovedAddress{Exchange: *exchange, Coin: *coin, Chain: *chain, Address: *address, Memo: *memo})
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("approved", *exchange, *coin, *chain, *address, *memo)
+	case *revoke:
+		if err := transfer.Revoke(*exchange, *coin, *chain); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("revoked", *exchange, *coin, *chain)
+	case *limit:
+		if err := transfer.SetLimit(transfer.Limit{Coin: *coin, PerWithdrawal: *per, Daily: *daily}); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("limited", *coin, "to", *per, "per withdrawal and", *daily, "a day")
+	default:
+		addresses, err := transfer.Addresses()
+		if err != nil {
+			log.Fatal("Failed to list addresses:", err)
+		}
+		for _, a := range addresses {
+			fmt.Printf("%-8s %-8s %-10s %s %s  %s\n", a.Exchange, a.Coin, a.Chain, a.Address, a.Memo, a.Approved.Format("2006-01-02 15:04:05"))
+		}
+		limits, err := transfer.Limits()
+		if err != nil {
+			log.Fatal("Failed to list limits:", err)
+		}
+		for _, l := range limits {
+			today, err := transfer.Withdrawn(l.Coin, time.Now())
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Printf("%-8s %v per withdrawal, %v a day, %v withdrawn today\n", l.Coin, l.PerWithdrawal, l.Daily, today)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"arbitrage/backtest"
+	"arbitrage/clock"
+	"arbitrage/exchange"
+	"arbitrage/recorder"
+	"arbitrage/replay"
+	"arbitrage/sim"
+	"arbitrage/utils"
+	"arbitrage/workflow"
+	"context"
+	"flag"
+	"log"
+	"os"
+	"time"
+)
+
+// runBacktest runs the strategy over recorded quotes against simulated
+// exchanges and prints a report of how it did. With -settle transfer the
+// rebalance workflows run on the simulated exchanges too, on recorded time,
+// so that coins and collateral in transit for -transfer-delay are missing
+// from the wallets meanwhile.
+//
+//	arbitrage backtest -data recordings -fee 0.001 -latency 150ms
+func runBacktest(args []string) {
+	flags := flag.NewFlagSet("backtest", flag.ExitOnError)
+	dataDir := flags.String("data", "", "directory of recorded quotes")
+	startBalance := flags.Float64("balance", 2*CAPITAL, "USDT in the spot wallet of each exchange at the start")
+	cfg := simFlags(flags)
+	flags.StringVar(&settleMode, "settle", settleMode, settleUsage)
+	flags.Parse(args)
+	if settleMode != settleTrade && settleMode != settleTransfer {
+		log.Fatal("-settle must be trade or transfer")
+	}
+
+	if *dataDir == "" {
+		log.Fatal("backtest needs -data")
+	}
+	files, err := recorder.Files(*dataDir)
+	if err != nil {
+		log.Fatal("Failed to list recordings:", err)
+	}
+	if len(files) == 0 {
+		log.Fatal("No recordings found in ", *dataDir)
+	}
+
+	// Load everything first so that fills can look past the current quote by the latency
+	timeline := sim.NewTimeline()
+	var start int64
+	for _, name := range files {
+		err := replay.Read(name, func(rec recorder.Record) error {
+			if start == 0 {
+				start = rec.Received
+			}
+			timeline.Add(rec.Ticker())
+			return nil
+		})
+		if err != nil {
+			log.Fatal("Failed to read recording:", err)
+		}
+	}
+
+	virtual := clock.NewVirtual(time.UnixMicro(start))
+	clk = virtual
+
+	var exchanges []*sim.Exchange
+	for _, name := range []string{"BINANCE", "KUCOIN"} {
+		ex := sim.New(name, *cfg, timeline.Market(name), virtual)
+		ex.Deposit(exchange.SPOT, "USDT", *startBalance)
+		exchange.Register(ex)
+		exchanges = append(exchanges, ex)
+	}
+
+	db, err := utils.MemoryDatabase()
+	if err != nil {
+		log.Fatal("Failed to open memory database:", err)
+	}
+	defer db.Close()
+	workflow.Use(db)
+	workflow.Drive(virtual)
+
+	tracker := backtest.NewTracker(exchanges...)
+	for _, ex := range exchanges {
+		ex.OnFill = func(sim.Fill) {
+			tracker.Sample(virtual.Now())
+		}
+	}
+	tracker.Sample(virtual.Now())
+
+	tickers := make(chan exchange.TickerGeneral)
+	player := &replay.Player{Files: files}
+	go func() {
+		defer close(tickers)
+		if err := player.Run(context.Background(), tickers); err != nil {
+			log.Println("replay error:", err)
+		}
+	}()
+
+	p := &processor{
+		db:      db,
+		inline:  true,
+		virtual: virtual,
+		afterTick: func(exchange.TickerGeneral) {
+			workflow.Advance(runCtx)
+			tracker.Sample(virtual.Now())
+		},
+	}
+	p.run(tickers)
+
+	tracker.Report().Print(os.Stdout)
+}
+
+// simFlags registers the costs of the simulated exchanges on flags.
+func simFlags(flags *flag.FlagSet) *sim.Config {
+	cfg := &sim.Config{}
+	flags.Float64Var(&cfg.TakerFee, "fee", 0.001, "taker fee as a fraction of the notional")
+	flags.Float64Var(&cfg.Impact, "impact", 0.0005, "price impact of each further top-of-book size filled")
+	flags.DurationVar(&cfg.Latency, "latency", 100*time.Millisecond, "delay between placing an order and its fill")
+	flags.Float64Var(&cfg.BorrowRate, "borrow-rate", 0.0001/24, "margin interest per hour as a fraction of the loan")
+	flags.DurationVar(&cfg.TransferDelay, "transfer-delay", 30*time.Minute, "delay until a withdrawal is credited, with -settle transfer")
+	return cfg
+}
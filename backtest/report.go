@@ -0,0 +1,171 @@
+// Package backtest measures how the strategy did against simulated exchanges.
+package backtest
+
+import (
+	"arbitrage/sim"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"time"
+)
+
+// dust is the position value in USDT below which a position counts as closed,
+// so that leftover rounding and unpaid interest do not keep a trade open.
+const dust = 1.0
+
+// Trade is one round trip, from the first fill that opened a position to the
+// fill that closed it.
+type Trade struct {
+	InstId string
+	Opened time.Time
+	Closed time.Time
+	PnL    float64
+}
+
+type Report struct {
+	Start       time.Time
+	End         time.Time
+	StartEquity float64
+	EndEquity   float64
+	Trades      []Trade // Closed trades
+	Open        *Trade  // Still open at the end of the data
+	Fills       int
+	Fees        float64
+
+	RealizedPnL    float64
+	WinRate        float64 // Fraction of closed trades with a positive PnL
+	MaxDrawdown    float64 // Largest fall of equity from a previous peak, in USDT
+	MaxDrawdownPct float64
+	Utilisation    float64 // Time-weighted average share of equity in positions
+}
+
+// Tracker samples the simulated exchanges as the backtest advances. Sample
+// after every fill as well as after every quote, so that a trade closed and
+// another opened on the same quote are told apart.
+type Tracker struct {
+	exchanges []*sim.Exchange
+
+	mu       sync.Mutex
+	report   Report
+	started  bool
+	last     time.Time
+	peak     float64
+	deployed float64 // Share of equity deployed at the last sample
+	weighted float64 // Sum of deployed share times duration
+	fills    int
+	open     *Trade
+	openAt   float64 // Equity when the open trade started
+}
+
+func NewTracker(exchanges ...*sim.Exchange) *Tracker {
+	return &Tracker{exchanges: exchanges}
+}
+
+// Sample records the state of the exchanges at now.
+func (t *Tracker) Sample(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var equity, deployed float64
+	var fills []sim.Fill
+	for _, ex := range t.exchanges {
+		e, d := ex.Value()
+		equity += e
+		deployed += d
+		fills = append(fills, ex.Fills()...)
+	}
+
+	if !t.started {
+		t.started = true
+		t.report.Start = now
+		t.report.StartEquity = equity
+		t.report.EndEquity = equity
+		t.peak = equity
+		t.last = now
+	}
+	if now.After(t.last) {
+		t.weighted += t.deployed * now.Sub(t.last).Seconds()
+		t.last = now
+	}
+	if equity > 0 {
+		t.deployed = deployed / equity
+	}
+
+	if equity > t.peak {
+		t.peak = equity
+	}
+	if drawdown := t.peak - equity; drawdown > t.report.MaxDrawdown {
+		t.report.MaxDrawdown = drawdown
+		t.report.MaxDrawdownPct = drawdown / t.peak
+	}
+
+	newFills := len(fills) > t.fills
+	if newFills {
+		instId := fills[len(fills)-1].InstId
+		if t.open == nil && deployed >= dust {
+			t.open = &Trade{InstId: instId, Opened: now}
+			t.openAt = t.report.EndEquity // Equity at the previous sample, before the opening fills
+		}
+		t.fills = len(fills)
+	}
+	if t.open != nil && deployed < dust {
+		t.open.Closed = now
+		t.open.PnL = equity - t.openAt
+		t.report.Trades = append(t.report.Trades, *t.open)
+		t.open = nil
+	}
+
+	t.report.End = now
+	t.report.EndEquity = equity
+	t.report.Fills = len(fills)
+	t.report.Fees = 0
+	for _, f := range fills {
+		t.report.Fees += f.Fee
+	}
+}
+
+// Report summarizes everything sampled so far.
+func (t *Tracker) Report() Report {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	r := t.report
+	r.Trades = append([]Trade(nil), t.report.Trades...)
+	if t.open != nil {
+		open := *t.open
+		open.PnL = r.EndEquity - t.openAt
+		r.Open = &open
+	}
+
+	wins := 0
+	for _, trade := range r.Trades {
+		r.RealizedPnL += trade.PnL
+		if trade.PnL > 0 {
+			wins++
+		}
+	}
+	if len(r.Trades) > 0 {
+		r.WinRate = float64(wins) / float64(len(r.Trades))
+	}
+	if span := r.End.Sub(r.Start).Seconds(); span > 0 {
+		r.Utilisation = math.Min(t.weighted/span, 1)
+	}
+	return r
+}
+
+func (r Report) Print(w io.Writer) {
+	fmt.Fprintf(w, "period:        %s to %s (%s)\n", r.Start.UTC().Format(time.RFC3339), r.End.UTC().Format(time.RFC3339), r.End.Sub(r.Start).Round(time.Second))
+	fmt.Fprintf(w, "equity:        %.2f -> %.2f USDT\n", r.StartEquity, r.EndEquity)
+	fmt.Fprintf(w, "trades:        %d closed, %d fills, %.2f USDT fees\n", len(r.Trades), r.Fills, r.Fees)
+	for _, trade := range r.Trades {
+		fmt.Fprintf(w, "  %-12s %s  held %-10s pnl %10.2f\n", trade.InstId, trade.Opened.UTC().Format(time.RFC3339), trade.Closed.Sub(trade.Opened).Round(time.Second), trade.PnL)
+	}
+	if r.Open != nil {
+		fmt.Fprintf(w, "  %-12s %s  still open    pnl %10.2f (unrealized)\n", r.Open.InstId, r.Open.Opened.UTC().Format(time.RFC3339), r.Open.PnL)
+	}
+	fmt.Fprintf(w, "realized pnl:  %.2f USDT\n", r.RealizedPnL)
+	fmt.Fprintf(w, "win rate:      %.1f%%\n", r.WinRate*100)
+	fmt.Fprintf(w, "max drawdown:  %.2f USDT (%.2f%%)\n", r.MaxDrawdown, r.MaxDrawdownPct*100)
+	fmt.Fprintf(w, "utilisation:   %.1f%%\n", r.Utilisation*100)
+}
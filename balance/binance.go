@@ -1,29 +1,25 @@
 package balance
 
 import (
+	"arbitrage/rest"
 	"context"
 	"log"
 	"fmt"
 	"net/http"
 	"strconv"
-	"time"
 	"io"
     "encoding/json"
 	"net/url"
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/hex"
 
-	"github.com/adshao/go-binance/v2"
 )
 
 func Binance() ([]AccountBalance, error) {
-	client := binance.NewClient(binanceAPIKey, binanceAPISecret)
+	client := rest.BinanceClient()
 
 	// Get account information including balances
-	accountInfo, err := client.NewGetAccountService().Do(context.Background())
+	accountInfo, err := client.NewGetAccountService().Do(context.Background(), rest.RecvWindow())
 	if err != nil {
-		log.Fatalf("Error fetching Binance account info: %v", err)
+		return nil, fmt.Errorf("binance account: %w", err)
 	}
 
 	var balances []AccountBalance
@@ -60,12 +56,12 @@ func Binance() ([]AccountBalance, error) {
 	return append(balances, funding...), nil
 }
 func binanceMargin() ([]AccountBalance, error) {
-	client := binance.NewClient(binanceAPIKey, binanceAPISecret)
+	client := rest.BinanceClient()
 
 	// Get account information including balances
-	accountInfo, err := client.NewGetMarginAccountService().Do(context.Background())
+	accountInfo, err := client.NewGetMarginAccountService().Do(context.Background(), rest.RecvWindow())
 	if err != nil {
-		log.Fatalf("Error fetching Binance account info: %v", err)
+		return nil, fmt.Errorf("binance margin account: %w", err)
 	}
 
 	var balances []AccountBalance
@@ -97,13 +93,10 @@ func binanceFunding() ([]AccountBalance, error) {
 	endpoint := "https://api.binance.com/sapi/v1/asset/get-funding-asset"
 
 	// Set parameters
-	timestamp := strconv.FormatInt(time.Now().UnixMilli(), 10)
 	params := url.Values{}
-	params.Set("timestamp", timestamp)
 
-	// Generate signature
-	signature := GenerateSignature(params.Encode(), binanceAPISecret)
-	params.Set("signature", signature)
+	// Sign the request
+	rest.SignBinance(params)
 
 	// Create the request
 	req, err := http.NewRequest("POST", endpoint+"?"+params.Encode(), nil)
@@ -115,8 +108,7 @@ func binanceFunding() ([]AccountBalance, error) {
 	req.Header.Set("X-MBX-APIKEY", binanceAPIKey)
 
 	// Make the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := rest.Binance.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -128,8 +120,8 @@ func binanceFunding() ([]AccountBalance, error) {
 		return nil, err
 	}
 	// Handle errors from Binance API
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("binance funding API request failed: %s", string(body))
+	if err := rest.BinanceError(resp, body); err != nil {
+		return nil, fmt.Errorf("binance funding: %w", err)
 	}
 
 	// Parse the response
@@ -150,10 +142,3 @@ func binanceFunding() ([]AccountBalance, error) {
 
 	return balances, nil
 }
-
-
-func GenerateSignature(queryString, secret string) string {
-	h := hmac.New(sha256.New, []byte(secret))
-	h.Write([]byte(queryString))
-	return hex.EncodeToString(h.Sum(nil))
-}
\ No newline at end of file
@@ -1,9 +1,12 @@
 package balance
 
 import (
+	"arbitrage/rest"
+	"fmt"
+	"io"
 	"log"
+	"net/http"
 	"strconv"
-	"github.com/Kucoin/kucoin-go-sdk"
 )
 
 // AccountBalance represents the balance of a specific account.
@@ -15,26 +18,37 @@ type AccountBalance struct {
 
 // Helper function to get account balances from a specific endpoint.
 func Kucoin()([]AccountBalance, error) {
-	s := kucoin.NewApiService(
-		kucoin.ApiKeyOption(kucoinAPIKey),
-		kucoin.ApiSecretOption(kucoinAPISecret),
-		kucoin.ApiPassPhraseOption(kucoinPassphrase),
-	)
-	rsp, err := s.Accounts("", "")
+	endpoint := "/api/v1/accounts"
+	req, err := http.NewRequest("GET", rest.KucoinURL+endpoint, nil)
 	if err != nil {
-		log.Printf("Error: %s", err.Error())
 		return []AccountBalance{}, err
 	}
+	rest.SignKucoin(req, endpoint, "")
 
-	as := kucoin.AccountsModel{}
-	if err := rsp.ReadData(&as); err != nil {
+	resp, err := rest.Kucoin.Do(req)
+	if err != nil {
 		log.Printf("Error: %s", err.Error())
 		return []AccountBalance{}, err
 	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return []AccountBalance{}, err
+	}
+
+	var accounts []struct {
+		Currency  string `json:"currency"`
+		Type      string `json:"type"`
+		Available string `json:"available"`
+	}
+	if err := rest.DecodeKucoin(resp, body, &accounts); err != nil {
+		return []AccountBalance{}, fmt.Errorf("kucoin accounts: %w", err)
+	}
 
 	balances := []AccountBalance{}
 
-	for _, a := range as {
+	for _, a := range accounts {
 		balances = append(balances, AccountBalance{
 			Currency: a.Currency,
 			Balance:  func() float64 {
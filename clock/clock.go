@@ -0,0 +1,53 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the time source of the strategy, so that replays and backtests can
+// run it on recorded time.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+// Real is the wall clock.
+type Real struct{}
+
+func (Real) Now() time.Time { return time.Now() }
+
+func (Real) Sleep(d time.Duration) { time.Sleep(d) }
+
+// Virtual only moves when told to. Set moves it to the time of the data being
+// replayed and Sleep advances it without blocking, so a replay runs as fast
+// as its data is fed.
+type Virtual struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func NewVirtual(start time.Time) *Virtual {
+	return &Virtual{now: start}
+}
+
+func (v *Virtual) Now() time.Time {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.now
+}
+
+// Set moves the clock to t. The clock never goes backwards.
+func (v *Virtual) Set(t time.Time) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if t.After(v.now) {
+		v.now = t
+	}
+}
+
+func (v *Virtual) Sleep(d time.Duration) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.now = v.now.Add(d)
+}
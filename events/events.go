@@ -0,0 +1,113 @@
+// Package events carries private account updates, pushed by the exchanges'
+// user-data streams, to whoever is waiting on them. Streams can drop, so
+// every consumer keeps polling as a fallback and treats an event as a hint
+// to look sooner.
+package events
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// Event is one normalized account update. Exactly one of Order, Balance and
+// Debt is set.
+type Event struct {
+	Exchange string
+	Time     time.Time
+
+	Order   *Order
+	Balance *Balance
+	Debt    *Debt
+}
+
+// Order is the state of an order after an update.
+type Order struct {
+	MarketType  string // SPOT or MARGIN, empty when the exchange does not say
+	InstId      string
+	Side        string // order.Buy or order.Sell
+	OrderId     string
+	ClientId    string
+	Status      string // One of the order.Status constants
+	Quantity    float64
+	ExecutedQty float64 // In total so far
+	LastQty     float64 // Of the trade that caused the update, if any
+	LastPrice   float64
+}
+
+// Balance is the balance of one asset in one wallet after a change.
+type Balance struct {
+	Wallet    string // SPOT, MARGIN or FUNDING
+	Asset     string
+	Available float64
+	Hold      float64
+}
+
+// Debt is the outstanding margin loan of one asset.
+type Debt struct {
+	Asset  string
+	Amount float64
+}
+
+// subscriberBuffer is how many events a subscriber may fall behind before
+// events to it are dropped.
+const subscriberBuffer = 256
+
+var (
+	mu          sync.Mutex
+	subscribers = make(map[chan Event]struct{})
+)
+
+// Subscribe returns a channel receiving every event published from now on,
+// and a function that ends the subscription.
+func Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, subscriberBuffer)
+	mu.Lock()
+	subscribers[ch] = struct{}{}
+	mu.Unlock()
+	return ch, func() {
+		mu.Lock()
+		delete(subscribers, ch)
+		mu.Unlock()
+	}
+}
+
+// Publish hands e to every subscriber without blocking; a subscriber that
+// fell behind misses it.
+func Publish(e Event) {
+	mu.Lock()
+	defer mu.Unlock()
+	for ch := range subscribers {
+		select {
+		case ch <- e:
+		default:
+			log.Printf("events: subscriber behind, dropped %s update", e.Exchange)
+		}
+	}
+}
+
+// WaitFor blocks until an event for which match returns true is published
+// or timeout passes, whichever comes first.
+func WaitFor(timeout time.Duration, match func(Event) bool) (Event, bool) {
+	ch, unsubscribe := Subscribe()
+	defer unsubscribe()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case e := <-ch:
+			if match(e) {
+				return e, true
+			}
+		case <-timer.C:
+			return Event{}, false
+		}
+	}
+}
+
+// BalanceChanged matches balance updates of asset in wallet on exchange.
+func BalanceChanged(exchange, wallet, asset string) func(Event) bool {
+	return func(e Event) bool {
+		return e.Balance != nil && e.Exchange == exchange && e.Balance.Wallet == wallet && e.Balance.Asset == asset
+	}
+}
@@ -0,0 +1,369 @@
+package exchange
+
+import (
+	"arbitrage/balance"
+	"arbitrage/events"
+	"arbitrage/order"
+	"arbitrage/orderbook"
+	"arbitrage/rest"
+	"arbitrage/transfer"
+	"arbitrage/utils"
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/gorilla/websocket"
+)
+
+// Define structures
+type BinanceTicker struct {
+	UpdateID int64  `json:"u"`
+	Symbol   string `json:"s"`
+	BidPrice string `json:"b"`
+	BidQty   string `json:"B"`
+	AskPrice string `json:"a"`
+	AskQty   string `json:"A"`
+}
+
+// Binance adapts the order, transfer and balance packages to the Exchange interface.
+type Binance struct {
+	instIds []string
+	books   *orderbook.Books
+}
+
+// NewBinance streams and trades instIds, normally the result of universe.Build.
+func NewBinance(instIds []string) *Binance {
+	return &Binance{instIds: instIds, books: orderbook.NewBooks()}
+}
+
+func (b *Binance) Name() string {
+	return "BINANCE"
+}
+
+func (b *Binance) Order(marketType, instId string, quantity float64) (order.OrderResult, error) {
+	return confirm(order.Binance(marketType, quantity, instId, reference(b.Book(instId), marketType == MARGIN)))
+}
+
+func (b *Binance) Reverse(marketType, instId string, quantity float64) (order.OrderResult, error) {
+	return confirm(order.BinanceReverse(marketType, quantity, instId, reference(b.Book(instId), marketType != MARGIN)))
+}
+
+func (b *Binance) Limit(marketType, side, instId string, price, quantity float64, timeInForce string) (order.OrderResult, error) {
+	result, err := order.BinanceLimit(marketType, side, timeInForce, price, quantity, instId)
+	return confirmLimit(timeInForce, result, err)
+}
+
+func (b *Binance) Cancel(result order.OrderResult) (order.OrderResult, error) {
+	return order.Cancel(result)
+}
+
+func (b *Binance) Balances() ([]balance.AccountBalance, error) {
+	var balances []balance.AccountBalance
+	err := rest.Retry(rest.DefaultPolicy, "binance balances", func() (err error) {
+		balances, err = balance.Binance()
+		return err
+	})
+	return balances, err
+}
+
+func (b *Binance) Transfer(asset, from, to string, amount float64) (string, error) {
+	switch {
+	case from == SPOT && to == MARGIN:
+		return transfer.BinanceSpot2Margin(asset, amount)
+	case from == MARGIN && to == SPOT:
+		return transfer.BinanceMargin2Spot(asset, amount)
+	case from == FUNDING && to == SPOT:
+		return transfer.BinanceFunding2Spot(asset, amount)
+	}
+	return "", unsupportedTransfer(b.Name(), from, to)
+}
+
+func (b *Binance) Withdraw(asset string, amount float64, address transfer.DepositAdress) (string, error) {
+	return transfer.BinanceWithdraw(asset, amount, address)
+}
+
+func (b *Binance) Networks(asset string) ([]transfer.Network, error) {
+	var networks []transfer.Network
+	err := rest.Retry(rest.DefaultPolicy, "binance networks", func() (err error) {
+		networks, err = transfer.BinanceNetworks(asset)
+		return err
+	})
+	return networks, err
+}
+
+func (b *Binance) DepositAddress(asset, network string) (transfer.DepositAdress, error) {
+	var address transfer.DepositAdress
+	err := rest.Retry(rest.DefaultPolicy, "binance deposit address", func() (err error) {
+		address, err = transfer.BinanceDepositAddressOn(asset, network)
+		return err
+	})
+	return address, err
+}
+
+func (b *Binance) Withdrawal(asset, id string) (transfer.Withdrawal, error) {
+	var withdrawal transfer.Withdrawal
+	err := rest.Retry(rest.DefaultPolicy, "binance withdrawal", func() (err error) {
+		withdrawal, err = transfer.BinanceWithdrawal(asset, id)
+		return err
+	})
+	return withdrawal, err
+}
+
+func (b *Binance) DepositByTx(asset, txId string) (transfer.Deposit, error) {
+	var deposit transfer.Deposit
+	err := rest.Retry(rest.DefaultPolicy, "binance deposit", func() (err error) {
+		deposit, err = transfer.BinanceDeposit(asset, txId)
+		return err
+	})
+	return deposit, err
+}
+
+func (b *Binance) RepayLoan(asset string, amount float64) (string, error) {
+	return transfer.BinanceRepayMarginLoan(asset, amount)
+}
+
+// Book returns the local order book of instId, or nil when it is not maintained.
+func (b *Binance) Book(instId string) *orderbook.Book {
+	return b.books.Get(instId)
+}
+
+func (b *Binance) Stream(ctx context.Context, tickers chan TickerGeneral) {
+	trie := utils.Initialize(b.instIds)
+
+	go supervise(ctx, "binance depth", func(ctx context.Context) error {
+		return orderbook.SyncBinance(ctx, b.instIds, b.books)
+	}, nil)
+
+	supervise(ctx, "binance", func(ctx context.Context) error {
+		return b.bookTicker(ctx, trie, tickers)
+	}, func() {
+		invalidate(ctx, tickers, b.Name())
+	})
+	log.Println("binance context done")
+}
+
+// bookTicker forwards best bid and ask updates until ctx is cancelled or the
+// connection fails.
+func (b *Binance) bookTicker(ctx context.Context, trie *utils.TrieNode, tickers chan TickerGeneral) error {
+	// Binance WebSocket endpoint for order book data - one stream per universe symbol
+	endpoint := "wss://stream.binance.com:9443/ws/" + binanceStreams(b.instIds, "@bookTicker")
+
+	// Connect to the WebSocket
+	c, _, err := websocket.DefaultDialer.Dial(endpoint, nil)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			err := c.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+			if err != nil {
+				log.Println("write close:", err)
+			}
+			c.Close() // Unblocks ReadMessage
+		case <-done:
+		}
+	}()
+
+	for {
+		// A silent connection is as bad as a closed one
+		c.SetReadDeadline(time.Now().Add(readTimeout))
+		_, message, err := c.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		var tickerData BinanceTicker
+		err = json.Unmarshal(message, &tickerData)
+		if err != nil {
+			log.Println("decode error binance", err)
+			continue
+		}
+
+		// Validate the order book data
+		if tickerData.Symbol == "" {
+			log.Println("empty symbol received")
+			continue
+		}
+
+		// Forward the best bid and ask
+		if tickerData.AskPrice != "" && tickerData.AskQty != "" && tickerData.BidPrice != "" && tickerData.BidQty != "" {
+			received := time.Now()
+			symbol := utils.GetQuote(tickerData.Symbol, trie)
+			if symbol == "" {
+				continue
+			}
+			ticker := TickerGeneral{
+				InstId:   symbol,
+				Market:   b.Name(),
+				BidPrice: tickerData.BidPrice,
+				BidSize:  tickerData.BidQty,
+				AskPrice: tickerData.AskPrice,
+				AskSize:  tickerData.AskQty,
+				// bookTicker carries no event time, so the receive time stands in for it
+				Time:     received.UnixMilli(),
+				Received: received.UnixMicro(),
+			}
+			select {
+			case tickers <- ticker:
+			case <-ctx.Done():
+				return nil
+			}
+		} else {
+			log.Printf("invalid Binance book data for symbol %s", tickerData.Symbol)
+			log.Printf("full message: %s", string(message))
+		}
+	}
+}
+
+// UserStream publishes the order and balance updates of the spot and cross
+// margin accounts until ctx is cancelled.
+func (b *Binance) UserStream(ctx context.Context) {
+	client := rest.BinanceClient()
+	trie := utils.Initialize(b.instIds)
+
+	go supervise(ctx, "binance margin user stream", func(ctx context.Context) error {
+		return b.userStream(ctx, client, trie, MARGIN)
+	}, nil)
+	supervise(ctx, "binance user stream", func(ctx context.Context) error {
+		return b.userStream(ctx, client, trie, SPOT)
+	}, nil)
+}
+
+// userStream opens a listenKey for wallet, keeps it alive and publishes its
+// events until ctx is cancelled or the connection fails.
+func (b *Binance) userStream(ctx context.Context, client *binance.Client, trie *utils.TrieNode, wallet string) error {
+	var listenKey string
+	var err error
+	if wallet == MARGIN {
+		listenKey, err = client.NewStartMarginUserStreamService().Do(ctx)
+	} else {
+		listenKey, err = client.NewStartUserStreamService().Do(ctx)
+	}
+	if err != nil {
+		return err
+	}
+
+	c, _, err := websocket.DefaultDialer.Dial("wss://stream.binance.com:9443/ws/"+listenKey, nil)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	// The stream is silent without account activity; Binance pings every few minutes
+	c.SetPingHandler(func(data string) error {
+		c.SetReadDeadline(time.Now().Add(userStreamReadTimeout))
+		return c.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(10*time.Second))
+	})
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		// A listenKey expires 60 minutes after the last keepalive
+		keepalive := time.NewTicker(30 * time.Minute)
+		defer keepalive.Stop()
+		for {
+			select {
+			case <-keepalive.C:
+				var err error
+				if wallet == MARGIN {
+					err = client.NewKeepaliveMarginUserStreamService().ListenKey(listenKey).Do(ctx)
+				} else {
+					err = client.NewKeepaliveUserStreamService().ListenKey(listenKey).Do(ctx)
+				}
+				if err != nil {
+					log.Println("binance listenKey keepalive:", err)
+				}
+			case <-ctx.Done():
+				c.Close() // Unblocks ReadMessage
+				return
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for {
+		c.SetReadDeadline(time.Now().Add(userStreamReadTimeout))
+		_, message, err := c.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		b.publishUserData(trie, wallet, message)
+	}
+}
+
+// publishUserData normalizes one user data message of wallet.
+func (b *Binance) publishUserData(trie *utils.TrieNode, wallet string, message []byte) {
+	var head struct {
+		Event string `json:"e"`
+		Time  int64  `json:"E"`
+	}
+	if err := json.Unmarshal(message, &head); err != nil {
+		log.Println("decode error binance user stream", err)
+		return
+	}
+	at := time.UnixMilli(head.Time)
+
+	switch binance.UserDataEventType(head.Event) {
+	case binance.UserDataEventTypeOutboundAccountPosition:
+		var update binance.WsAccountUpdateList
+		if err := json.Unmarshal(message, &update); err != nil {
+			log.Println("decode error binance user stream", err)
+			return
+		}
+		for _, a := range update.WsAccountUpdates {
+			events.Publish(events.Event{Exchange: b.Name(), Time: at, Balance: &events.Balance{
+				Wallet:    wallet,
+				Asset:     a.Asset,
+				Available: parseFloat(a.Free),
+				Hold:      parseFloat(a.Locked),
+			}})
+		}
+	case binance.UserDataEventTypeExecutionReport:
+		var update binance.WsOrderUpdate
+		if err := json.Unmarshal(message, &update); err != nil {
+			log.Println("decode error binance user stream", err)
+			return
+		}
+		instId := utils.GetQuote(update.Symbol, trie)
+		if instId == "" {
+			instId = update.Symbol
+		}
+		clientId := update.ClientOrderId
+		if update.OrigCustomOrderId != "" {
+			clientId = update.OrigCustomOrderId // A cancel carries the ID of the cancel request in c
+		}
+		status := update.Status
+		if status == "EXPIRED_IN_MATCH" {
+			status = order.StatusExpired
+		}
+		events.Publish(events.Event{Exchange: b.Name(), Time: at, Order: &events.Order{
+			MarketType:  wallet,
+			InstId:      instId,
+			Side:        strings.ToLower(update.Side),
+			OrderId:     strconv.FormatInt(update.Id, 10),
+			ClientId:    clientId,
+			Status:      status,
+			Quantity:    parseFloat(update.Volume),
+			ExecutedQty: parseFloat(update.FilledVolume),
+			LastQty:     parseFloat(update.LatestVolume),
+			LastPrice:   parseFloat(update.LatestPrice),
+		}})
+	}
+}
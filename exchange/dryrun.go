@@ -0,0 +1,105 @@
+package exchange
+
+import (
+	"arbitrage/balance"
+	"arbitrage/order"
+	"arbitrage/orderbook"
+	"arbitrage/transfer"
+	"context"
+	"log"
+)
+
+// DryRun stands in for a venue when quotes come from a recording. Every
+// trading call is logged and reported as successful without reaching the
+// exchange.
+type DryRun struct {
+	name string
+}
+
+func NewDryRun(name string) *DryRun {
+	return &DryRun{name: name}
+}
+
+func (d *DryRun) Name() string {
+	return d.name
+}
+
+// Stream does nothing; quotes are fed by the replay.
+func (d *DryRun) Stream(ctx context.Context, tickers chan TickerGeneral) {}
+
+func (d *DryRun) Book(instId string) *orderbook.Book {
+	return nil
+}
+
+func (d *DryRun) Order(marketType, instId string, quantity float64) (order.OrderResult, error) {
+	log.Printf("dry run %s: order %s %s %f", d.name, marketType, instId, quantity)
+	return d.filled(marketType, instId, quantity), nil
+}
+
+func (d *DryRun) Reverse(marketType, instId string, quantity float64) (order.OrderResult, error) {
+	log.Printf("dry run %s: reverse %s %s %f", d.name, marketType, instId, quantity)
+	return d.filled(marketType, instId, quantity), nil
+}
+
+func (d *DryRun) Limit(marketType, side, instId string, price, quantity float64, timeInForce string) (order.OrderResult, error) {
+	log.Printf("dry run %s: limit %s %s %s %f at %f %s", d.name, marketType, side, instId, quantity, price, timeInForce)
+	result := d.filled(marketType, instId, quantity)
+	result.Side, result.Type, result.TimeInForce, result.AvgPrice = side, order.Limit, timeInForce, price
+	return result, nil
+}
+
+// Cancel has nothing to do, every dry run order fills right away.
+func (d *DryRun) Cancel(result order.OrderResult) (order.OrderResult, error) {
+	return result, nil
+}
+
+// filled reports a market order as fully executed. The price is unknown.
+func (d *DryRun) filled(marketType, instId string, quantity float64) order.OrderResult {
+	return order.OrderResult{
+		Exchange:    d.name,
+		InstId:      instId,
+		Type:        order.Market,
+		MarketType:  marketType,
+		Status:      order.StatusFilled,
+		Quantity:    quantity,
+		ExecutedQty: quantity,
+	}
+}
+
+func (d *DryRun) Balances() ([]balance.AccountBalance, error) {
+	return nil, nil
+}
+
+func (d *DryRun) Transfer(asset, from, to string, amount float64) (string, error) {
+	log.Printf("dry run %s: transfer %f %s from %s to %s", d.name, amount, asset, from, to)
+	return "", nil
+}
+
+func (d *DryRun) Withdraw(asset string, amount float64, address transfer.DepositAdress) (string, error) {
+	log.Printf("dry run %s: withdraw %f %s to %s", d.name, amount, asset, address.Adress)
+	return "", nil
+}
+
+// Networks reports one chain that every dry-run exchange supports.
+func (d *DryRun) Networks(asset string) ([]transfer.Network, error) {
+	return []transfer.Network{{Name: "DRYRUN", Chain: "DRYRUN", DepositEnabled: true, WithdrawEnabled: true}}, nil
+}
+
+func (d *DryRun) DepositAddress(asset, network string) (transfer.DepositAdress, error) {
+	return transfer.DepositAdress{Exchange: d.name, Chain: network}, nil
+}
+
+// Withdrawal reports dry-run withdrawals as sent right away.
+func (d *DryRun) Withdrawal(asset, id string) (transfer.Withdrawal, error) {
+	return transfer.Withdrawal{Id: id, Asset: asset, TxId: id, Status: transfer.TransferCompleted}, nil
+}
+
+// DepositByTx reports dry-run deposits as credited right away.
+func (d *DryRun) DepositByTx(asset, txId string) (transfer.Deposit, error) {
+	return transfer.Deposit{Asset: asset, TxId: txId, Status: transfer.TransferCompleted}, nil
+}
+
+func (d *DryRun) RepayLoan(asset string, amount float64) (string, error) {
+	log.Printf("dry run %s: repay %f %s", d.name, amount, asset)
+	return "", nil
+}
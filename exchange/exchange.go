@@ -0,0 +1,173 @@
+package exchange
+
+import (
+	"arbitrage/balance"
+	"arbitrage/order"
+	"arbitrage/orderbook"
+	"arbitrage/transfer"
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Wallets understood by Transfer, matching balance.AccountBalance.Wallet.
+const (
+	SPOT    = "SPOT"
+	MARGIN  = "MARGIN"
+	FUNDING = "FUNDING"
+)
+
+// TickerGeneral is the best bid and offer of one symbol on one venue.
+type TickerGeneral struct {
+	InstId   string `json:"instId"`
+	Market   string `json:"Market"`
+	BidPrice string `json:"bidPrice"`
+	BidSize  string `json:"bidSize"`
+	AskPrice string `json:"askPrice"`
+	AskSize  string `json:"askSize"`
+	Time     int64  `json:"time"`     // exchange timestamp in milliseconds
+	Received int64  `json:"received"` // local receive timestamp in microseconds
+
+	// Stale marks every quote of Market as invalid until fresh data arrives.
+	// It is sent without an InstId when a venue disconnects.
+	Stale bool `json:"stale,omitempty"`
+}
+
+// Exchange is a venue the strategy can trade on. Adding a venue means writing
+// one implementation and registering it; the strategy only talks to this interface.
+type Exchange interface {
+	// Name is the market identifier carried in TickerGeneral.Market.
+	Name() string
+
+	// Stream forwards quotes into tickers until ctx is cancelled.
+	Stream(ctx context.Context, tickers chan TickerGeneral)
+	// Book returns the local order book of instId, or nil when it is not maintained.
+	Book(instId string) *orderbook.Book
+
+	// Order opens a leg: a buy on SPOT or a borrowed sell (short) on MARGIN.
+	// It returns once the order is final; an order that did not fully
+	// execute returns what did along with an *order.FillError.
+	Order(marketType, instId string, quantity float64) (order.OrderResult, error)
+	// Reverse closes a leg previously opened with Order.
+	Reverse(marketType, instId string, quantity float64) (order.OrderResult, error)
+	// Limit places a limit order of side on SPOT or MARGIN. IOC and FOK
+	// orders return once final, like Order; GTC and post-only orders return
+	// as placed and stay open until they fill or are cancelled.
+	Limit(marketType, side, instId string, price, quantity float64, timeInForce string) (order.OrderResult, error)
+	// Cancel cancels an order placed with Limit and returns its final state.
+	Cancel(result order.OrderResult) (order.OrderResult, error)
+
+	Balances() ([]balance.AccountBalance, error)
+	// Transfer moves funds between the SPOT, MARGIN and FUNDING wallets.
+	Transfer(asset, from, to string, amount float64) (string, error)
+	// Withdraw sends funds to an address returned by another venue's DepositAddress.
+	Withdraw(asset string, amount float64, address transfer.DepositAdress) (string, error)
+	// Networks lists the chains asset can be deposited and withdrawn over.
+	Networks(asset string) ([]transfer.Network, error)
+	// DepositAddress returns the address to deposit asset to over network,
+	// named as transfer.CanonicalNetwork names it.
+	DepositAddress(asset, network string) (transfer.DepositAdress, error)
+	// Withdrawal returns the state of a withdrawal by the ID Withdraw returned.
+	Withdrawal(asset, id string) (transfer.Withdrawal, error)
+	// DepositByTx returns the deposit made by the transaction txId, or
+	// transfer.ErrDepositNotFound while the exchange has not seen it.
+	DepositByTx(asset, txId string) (transfer.Deposit, error)
+	// RepayLoan repays a margin loan taken by a MARGIN Order.
+	RepayLoan(asset string, amount float64) (string, error)
+}
+
+// UserStreamer is implemented by exchanges with a private stream of account
+// updates. UserStream publishes them through the events package until ctx is
+// cancelled.
+type UserStreamer interface {
+	UserStream(ctx context.Context)
+}
+
+var (
+	mu        sync.RWMutex
+	exchanges = make(map[string]Exchange)
+)
+
+// Register makes an exchange available to the strategy under its Name.
+func Register(ex Exchange) {
+	mu.Lock()
+	defer mu.Unlock()
+	exchanges[ex.Name()] = ex
+}
+
+// Get returns the registered exchange with the given name.
+func Get(name string) (Exchange, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	ex, ok := exchanges[name]
+	if !ok {
+		return nil, fmt.Errorf("exchange %s is not registered", name)
+	}
+	return ex, nil
+}
+
+// All returns every registered exchange ordered by name.
+func All() []Exchange {
+	mu.RLock()
+	defer mu.RUnlock()
+	all := make([]Exchange, 0, len(exchanges))
+	for _, ex := range exchanges {
+		all = append(all, ex)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Name() < all[j].Name()
+	})
+	return all
+}
+
+// Route returns the deposit address on to for a withdrawal of asset from
+// from, on the cheapest network both support.
+func Route(asset string, from, to Exchange) (transfer.DepositAdress, error) {
+	return transfer.Route(asset, from.Networks, to.Networks, to.DepositAddress)
+}
+
+func unsupportedTransfer(name, from, to string) error {
+	return fmt.Errorf("%s: unsupported transfer from %s to %s", name, from, to)
+}
+
+// reference returns the best price a market order would take on book: the
+// best bid when it sells, the best ask when it buys. It is zero while the
+// book is not maintained or not in sync.
+func reference(book *orderbook.Book, sell bool) float64 {
+	if book == nil || !book.Synced() {
+		return 0
+	}
+	side := orderbook.Ask
+	if sell {
+		side = orderbook.Bid
+	}
+	best, ok := book.Best(side)
+	if !ok {
+		return 0
+	}
+	return best.Price
+}
+
+// confirm waits for an order placed on a real exchange to finish.
+func confirm(result order.OrderResult, err error) (order.OrderResult, error) {
+	if err != nil {
+		return result, err
+	}
+	return order.Confirm(result)
+}
+
+// confirmLimit waits for IOC and FOK orders, which finish right away, and
+// returns resting orders as placed.
+func confirmLimit(timeInForce string, result order.OrderResult, err error) (order.OrderResult, error) {
+	if timeInForce == order.IOC || timeInForce == order.FOK {
+		return confirm(result, err)
+	}
+	return result, err
+}
+
+func parseFloat(s string) float64 {
+	v, _ := strconv.ParseFloat(s, 64)
+	return v
+}
@@ -0,0 +1,421 @@
+package exchange
+
+import (
+	"arbitrage/balance"
+	"arbitrage/events"
+	"arbitrage/order"
+	"arbitrage/orderbook"
+	"arbitrage/rest"
+	"arbitrage/transfer"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Kucoin/kucoin-go-sdk"
+)
+
+const baseURL = "https://api.kucoin.com"
+
+type WebSocketTokenResponse struct {
+	Code string `json:"code"`
+	Data struct {
+		Token           string `json:"token"`
+		InstanceServers []struct {
+			Endpoint     string `json:"endpoint"`
+			Protocol     string `json:"protocol"`
+			Encrypt      bool   `json:"encrypt"`
+			PingInterval int    `json:"pingInterval"`
+			PingTimeout  int    `json:"pingTimeout"`
+		} `json:"instanceServers"`
+	} `json:"data"`
+}
+
+type WebSocketMessage struct {
+	Topic   string `json:"topic"`
+	Type    string `json:"type"`
+	Data    Data   `json:"data"`
+	Subject string `json:"subject"`
+}
+
+type Data struct {
+	BestAsk     string `json:"bestAsk"`
+	BestAskSize string `json:"bestAskSize"`
+	BestBid     string `json:"bestBid"`
+	BestBidSize string `json:"bestBidSize"`
+	Price       string `json:"price"`
+	Sequence    string `json:"sequence"`
+	Size        string `json:"size"`
+	Time        int64  `json:"time"`
+}
+
+// Kucoin adapts the order, transfer and balance packages to the Exchange interface.
+type Kucoin struct {
+	instIds []string
+	books   *orderbook.Books
+}
+
+// NewKucoin streams and trades instIds, normally the result of universe.Build.
+func NewKucoin(instIds []string) *Kucoin {
+	return &Kucoin{instIds: instIds, books: orderbook.NewBooks()}
+}
+
+func (k *Kucoin) Name() string {
+	return "KUCOIN"
+}
+
+func (k *Kucoin) Order(marketType, instId string, quantity float64) (order.OrderResult, error) {
+	return confirm(order.Kucoin(marketType, instId, quantity, reference(k.Book(instId), marketType == MARGIN)))
+}
+
+func (k *Kucoin) Reverse(marketType, instId string, quantity float64) (order.OrderResult, error) {
+	return confirm(order.KucoinReverse(marketType, instId, quantity, reference(k.Book(instId), marketType != MARGIN)))
+}
+
+func (k *Kucoin) Limit(marketType, side, instId string, price, quantity float64, timeInForce string) (order.OrderResult, error) {
+	result, err := order.KucoinLimit(marketType, instId, side, timeInForce, price, quantity)
+	return confirmLimit(timeInForce, result, err)
+}
+
+func (k *Kucoin) Cancel(result order.OrderResult) (order.OrderResult, error) {
+	return order.Cancel(result)
+}
+
+func (k *Kucoin) Balances() ([]balance.AccountBalance, error) {
+	var balances []balance.AccountBalance
+	err := rest.Retry(rest.DefaultPolicy, "kucoin balances", func() (err error) {
+		balances, err = balance.Kucoin()
+		return err
+	})
+	return balances, err
+}
+
+func (k *Kucoin) Transfer(asset, from, to string, amount float64) (string, error) {
+	switch {
+	case from == SPOT && to == MARGIN:
+		return transfer.KucoinSpot2Margin(asset, amount)
+	case from == MARGIN && to == SPOT:
+		return transfer.KucoinMargin2spot(asset, amount)
+	case from == FUNDING && to == SPOT:
+		return transfer.KucoinFunding2spot(asset, amount)
+	}
+	return "", unsupportedTransfer(k.Name(), from, to)
+}
+
+func (k *Kucoin) Withdraw(asset string, amount float64, address transfer.DepositAdress) (string, error) {
+	return transfer.KucoinWithdraw(asset, amount, address)
+}
+
+func (k *Kucoin) Networks(asset string) ([]transfer.Network, error) {
+	var networks []transfer.Network
+	err := rest.Retry(rest.DefaultPolicy, "kucoin networks", func() (err error) {
+		networks, err = transfer.KucoinNetworks(asset)
+		return err
+	})
+	return networks, err
+}
+
+func (k *Kucoin) DepositAddress(asset, network string) (transfer.DepositAdress, error) {
+	var address transfer.DepositAdress
+	err := rest.Retry(rest.DefaultPolicy, "kucoin deposit address", func() (err error) {
+		address, err = transfer.KucoinGetDepositAddress(asset, network)
+		return err
+	})
+	return address, err
+}
+
+func (k *Kucoin) Withdrawal(asset, id string) (transfer.Withdrawal, error) {
+	var withdrawal transfer.Withdrawal
+	err := rest.Retry(rest.DefaultPolicy, "kucoin withdrawal", func() (err error) {
+		withdrawal, err = transfer.KucoinWithdrawal(asset, id)
+		return err
+	})
+	return withdrawal, err
+}
+
+func (k *Kucoin) DepositByTx(asset, txId string) (transfer.Deposit, error) {
+	var deposit transfer.Deposit
+	err := rest.Retry(rest.DefaultPolicy, "kucoin deposit", func() (err error) {
+		deposit, err = transfer.KucoinDeposit(asset, txId)
+		return err
+	})
+	return deposit, err
+}
+
+func (k *Kucoin) RepayLoan(asset string, amount float64) (string, error) {
+	return transfer.KucoinRepayLoan(asset, amount)
+}
+
+// Book returns the local order book of instId, or nil when it is not maintained.
+func (k *Kucoin) Book(instId string) *orderbook.Book {
+	return k.books.Get(instId)
+}
+
+func (k *Kucoin) Stream(ctx context.Context, tickers chan TickerGeneral) {
+	s := kucoinService()
+
+	go supervise(ctx, "kucoin depth", func(ctx context.Context) error {
+		return orderbook.SyncKucoin(ctx, k.instIds, k.books)
+	}, nil)
+
+	supervise(ctx, "kucoin", func(ctx context.Context) error {
+		return k.publicWebsocket(ctx, s, tickers)
+	}, func() {
+		invalidate(ctx, tickers, k.Name())
+	})
+	log.Println("kucoin context done")
+}
+
+// publicWebsocket fetches a fresh public token, subscribes to the tickers and
+// forwards them until ctx is cancelled or the connection fails.
+func (k *Kucoin) publicWebsocket(ctx context.Context, s *kucoin.ApiService, tickers chan TickerGeneral) error {
+	rsp, err := s.WebSocketPublicToken()
+	if err != nil {
+		return err
+	}
+
+	tk := &kucoin.WebSocketTokenModel{}
+	if err := rsp.ReadData(tk); err != nil {
+		return err
+	}
+
+	c := s.NewWebSocketClient(tk)
+
+	mc, ec, err := c.Connect()
+	if err != nil {
+		return err
+	}
+	defer c.Stop() // Stop subscribing the WebSocket feed
+
+	for _, topic := range kucoinTopics("/market/ticker:", k.instIds) {
+		if err := c.Subscribe(kucoin.NewSubscribeMessage(topic, false)); err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-ec:
+			return err
+		case msg, ok := <-mc:
+			if !ok {
+				return fmt.Errorf("kucoin ticker stream closed")
+			}
+			received := time.Now()
+			var message WebSocketMessage
+			err := json.Unmarshal([]byte(kucoin.ToJsonString(msg)), &message)
+			if err != nil {
+				log.Println("decode error kucoin:", err, message)
+				continue
+			}
+			parts := strings.Split(message.Topic, ":")
+			if len(parts) < 2 {
+				continue
+			}
+			ticker := TickerGeneral{
+				InstId:   parts[1],
+				Market:   k.Name(),
+				BidPrice: message.Data.BestBid,
+				BidSize:  message.Data.BestBidSize,
+				AskPrice: message.Data.BestAsk,
+				AskSize:  message.Data.BestAskSize,
+				Time:     message.Data.Time,
+				Received: received.UnixMicro(),
+			}
+			select {
+			case tickers <- ticker:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+}
+
+// func orders(s *kucoin.ApiService) {
+// 	rsp, err := s.Orders(map[string]string{}, &kucoin.PaginationParam{CurrentPage: 1, PageSize: 10})
+// 	if err != nil {
+// 		log.Printf("Error: %s", err.Error())
+// 		return
+// 	}
+
+// 	os := kucoin.OrdersModel{}
+// 	pa, err := rsp.ReadPaginationData(&os)
+// 	if err != nil {
+// 		log.Printf("Error: %s", err.Error())
+// 		return
+// 	}
+// 	log.Printf("Total num: %d, total page: %d", pa.TotalNum, pa.TotalPage)
+// 	for _, o := range os {
+// 		log.Printf("Order: %s, %s, %s", o.Id, o.Type, o.Price)
+// 	}
+// }
+
+// UserStream publishes the order, balance and margin debt updates of the
+// account until ctx is cancelled.
+func (k *Kucoin) UserStream(ctx context.Context) {
+	s := kucoinService()
+
+	supervise(ctx, "kucoin user stream", func(ctx context.Context) error {
+		return k.privateWebsocket(ctx, s)
+	}, nil)
+}
+
+// kucoinService returns an SDK client with the credentials in the
+// environment, as the rest package signs KuCoin requests with.
+func kucoinService() *kucoin.ApiService {
+	return kucoin.NewApiService(
+		kucoin.ApiKeyOption(os.Getenv("KUCOIN_API_KEY")),
+		kucoin.ApiSecretOption(os.Getenv("KUCOIN_API_SECRET")),
+		kucoin.ApiPassPhraseOption(os.Getenv("KUCOIN_API_PASSPHRASE")),
+		kucoin.ApiKeyVersionOption(kucoin.ApiKeyVersionV2),
+	)
+}
+
+// kucoinOrderChange is a message of /spotMarket/tradeOrdersV2, which covers
+// spot and margin orders alike.
+type kucoinOrderChange struct {
+	Symbol     string `json:"symbol"`
+	Side       string `json:"side"`
+	OrderId    string `json:"orderId"`
+	ClientOid  string `json:"clientOid"`
+	Type       string `json:"type"` // received, open, match, update, filled or canceled
+	Size       string `json:"size"`
+	FilledSize string `json:"filledSize"`
+	MatchSize  string `json:"matchSize"`
+	MatchPrice string `json:"matchPrice"`
+	Ts         int64  `json:"ts"` // Nanoseconds
+}
+
+// kucoinBalanceChange is a message of /account/balance.
+type kucoinBalanceChange struct {
+	Currency      string `json:"currency"`
+	Available     string `json:"available"`
+	Hold          string `json:"hold"`
+	RelationEvent string `json:"relationEvent"` // e.g. trade.setted, main.deposit, margin.hold
+	Time          string `json:"time"`          // Milliseconds
+}
+
+// kucoinDebtRatio is the debt.ratio message of /margin/position.
+type kucoinDebtRatio struct {
+	DebtList  map[string]string `json:"debtList"`
+	Timestamp int64             `json:"timestamp"`
+}
+
+// privateWebsocket fetches a fresh private token, subscribes to the account
+// topics and publishes their events until ctx is cancelled or the connection
+// fails.
+func (k *Kucoin) privateWebsocket(ctx context.Context, s *kucoin.ApiService) error {
+	rsp, err := s.WebSocketPrivateToken()
+	if err != nil {
+		return err
+	}
+
+	tk := &kucoin.WebSocketTokenModel{}
+	if err := rsp.ReadData(tk); err != nil {
+		return err
+	}
+
+	c := s.NewWebSocketClient(tk)
+
+	mc, ec, err := c.Connect()
+	if err != nil {
+		return err
+	}
+	defer c.Stop()
+
+	for _, topic := range []string{"/spotMarket/tradeOrdersV2", "/account/balance", "/margin/position"} {
+		if err := c.Subscribe(kucoin.NewSubscribeMessage(topic, true)); err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-ec:
+			return err
+		case msg, ok := <-mc:
+			if !ok {
+				return fmt.Errorf("kucoin user stream closed")
+			}
+			if err := k.publishPrivate(msg); err != nil {
+				log.Println("decode error kucoin user stream:", err, msg.Topic)
+			}
+		}
+	}
+}
+
+// publishPrivate normalizes one private message.
+func (k *Kucoin) publishPrivate(msg *kucoin.WebSocketDownstreamMessage) error {
+	switch {
+	case msg.Topic == "/spotMarket/tradeOrdersV2":
+		var change kucoinOrderChange
+		if err := msg.ReadData(&change); err != nil {
+			return err
+		}
+		o := &events.Order{
+			MarketType:  order.MarketTypeOf(change.ClientOid), // The message does not say
+			InstId:      change.Symbol,
+			Side:        change.Side,
+			OrderId:     change.OrderId,
+			ClientId:    change.ClientOid,
+			Quantity:    parseFloat(change.Size),
+			ExecutedQty: parseFloat(change.FilledSize),
+			LastQty:     parseFloat(change.MatchSize),
+			LastPrice:   parseFloat(change.MatchPrice),
+		}
+		// Status names follow kucoinResult in the order package
+		switch change.Type {
+		case "filled":
+			o.Status = order.StatusFilled
+		case "canceled":
+			o.Status = order.StatusCanceled
+		default:
+			o.Status = order.StatusNew
+			if o.ExecutedQty > 0 {
+				o.Status = order.StatusPartiallyFilled
+			}
+		}
+		events.Publish(events.Event{Exchange: k.Name(), Time: time.Unix(0, change.Ts), Order: o})
+
+	case msg.Topic == "/account/balance":
+		var change kucoinBalanceChange
+		if err := msg.ReadData(&change); err != nil {
+			return err
+		}
+		wallet := SPOT
+		switch {
+		case strings.HasPrefix(change.RelationEvent, "main."):
+			wallet = FUNDING
+		case strings.HasPrefix(change.RelationEvent, "margin."):
+			wallet = MARGIN
+		}
+		ms, _ := strconv.ParseInt(change.Time, 10, 64)
+		events.Publish(events.Event{Exchange: k.Name(), Time: time.UnixMilli(ms), Balance: &events.Balance{
+			Wallet:    wallet,
+			Asset:     change.Currency,
+			Available: parseFloat(change.Available),
+			Hold:      parseFloat(change.Hold),
+		}})
+
+	case msg.Topic == "/margin/position" && msg.Subject == "debt.ratio":
+		var ratio kucoinDebtRatio
+		if err := msg.ReadData(&ratio); err != nil {
+			return err
+		}
+		for asset, amount := range ratio.DebtList {
+			events.Publish(events.Event{Exchange: k.Name(), Time: time.UnixMilli(ratio.Timestamp), Debt: &events.Debt{
+				Asset:  asset,
+				Amount: parseFloat(amount),
+			}})
+		}
+	}
+	return nil
+}
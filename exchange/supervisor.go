@@ -0,0 +1,79 @@
+package exchange
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"time"
+)
+
+const (
+	minBackoff = 1 * time.Second
+	maxBackoff = 1 * time.Minute
+
+	// Binance drops every connection after 24 hours; reconnecting earlier
+	// avoids losing data at an unpredictable moment.
+	maxConnectionAge = 23 * time.Hour
+
+	// readTimeout bounds how long a stream may stay silent before it is
+	// considered dead.
+	readTimeout = 1 * time.Minute
+
+	// userStreamReadTimeout is readTimeout for private streams, which are
+	// silent between account changes and kept alive by server pings.
+	userStreamReadTimeout = 5 * time.Minute
+)
+
+// supervise keeps a connection alive until ctx is cancelled. connect must
+// block while connected and return when the connection is lost; it is then
+// called again after an exponential backoff with jitter, so every connect
+// starts from a fresh token and resubscribes all topics. onDown runs after
+// each disconnect.
+func supervise(ctx context.Context, name string, connect func(ctx context.Context) error, onDown func()) {
+	backoff := minBackoff
+	for {
+		started := time.Now()
+		connCtx, cancel := context.WithTimeout(ctx, maxConnectionAge)
+		err := connect(connCtx)
+		expired := connCtx.Err() == context.DeadlineExceeded
+		cancel()
+
+		if onDown != nil {
+			onDown()
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if expired {
+			log.Printf("%s: connection reached %s, reconnecting", name, maxConnectionAge)
+			backoff = minBackoff
+			continue
+		}
+
+		// A connection that stayed up for a while starts the backoff over
+		if time.Since(started) > maxBackoff {
+			backoff = minBackoff
+		}
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		log.Printf("%s: disconnected (%v), reconnecting in %s", name, err, wait)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// invalidate tells the strategy that every quote of market is stale until
+// fresh data arrives.
+func invalidate(ctx context.Context, tickers chan TickerGeneral, market string) {
+	select {
+	case tickers <- TickerGeneral{Market: market, Stale: true, Received: time.Now().UnixMicro()}:
+	case <-ctx.Done():
+	}
+}
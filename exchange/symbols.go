@@ -0,0 +1,26 @@
+package exchange
+
+import "strings"
+
+// binanceStreams joins the Binance stream names of instIds, e.g. btcusdt@bookTicker.
+func binanceStreams(instIds []string, suffix string) string {
+	streams := make([]string, len(instIds))
+	for i, instId := range instIds {
+		streams[i] = strings.ToLower(strings.Replace(instId, "-", "", -1)) + suffix
+	}
+	return strings.Join(streams, "/")
+}
+
+// kucoinTopics splits instIds into topics of at most 100 symbols, the most
+// KuCoin accepts in one subscription, e.g. /market/ticker:BTC-USDT,ETH-USDT.
+func kucoinTopics(prefix string, instIds []string) []string {
+	var topics []string
+	for start := 0; start < len(instIds); start += 100 {
+		end := start + 100
+		if end > len(instIds) {
+			end = len(instIds)
+		}
+		topics = append(topics, prefix+strings.Join(instIds[start:end], ","))
+	}
+	return topics
+}
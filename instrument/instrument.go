@@ -0,0 +1,183 @@
+package instrument
+
+import (
+	"arbitrage/rest"
+	"arbitrage/universe"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Instrument holds the trading rules of one symbol on one exchange.
+type Instrument struct {
+	Exchange    string
+	InstId      string
+	TickSize    float64
+	StepSize    float64
+	MinQty      float64
+	MaxQty      float64
+	MinNotional float64
+
+	priceDecimals int
+	qtyDecimals   int
+}
+
+var (
+	mu          sync.RWMutex
+	instruments = make(map[string]map[string]Instrument) // Exchange -> InstId -> Instrument
+)
+
+// Load fetches the symbol rules of both exchanges and replaces the registry.
+func Load() error {
+	var binanceSymbols []universe.BinanceSymbol
+	err := rest.Retry(rest.DefaultPolicy, "binance symbols", func() (err error) {
+		binanceSymbols, err = universe.BinanceSymbols()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	var kucoinSymbols []universe.KucoinSymbol
+	err = rest.Retry(rest.DefaultPolicy, "kucoin symbols", func() (err error) {
+		kucoinSymbols, err = universe.KucoinSymbols()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	loaded := map[string]map[string]Instrument{
+		"BINANCE": make(map[string]Instrument),
+		"KUCOIN":  make(map[string]Instrument),
+	}
+	for _, s := range binanceSymbols {
+		inst := Instrument{Exchange: "BINANCE", InstId: s.BaseAsset + "-" + s.QuoteAsset}
+		for _, f := range s.Filters {
+			switch f.FilterType {
+			case "PRICE_FILTER":
+				inst.TickSize, inst.priceDecimals = parseIncrement(f.TickSize)
+			case "LOT_SIZE":
+				inst.StepSize, inst.qtyDecimals = parseIncrement(f.StepSize)
+				inst.MinQty = parseFloat(f.MinQty)
+				inst.MaxQty = parseFloat(f.MaxQty)
+			case "MIN_NOTIONAL", "NOTIONAL":
+				inst.MinNotional = parseFloat(f.MinNotional)
+			}
+		}
+		loaded["BINANCE"][inst.InstId] = inst
+	}
+	for _, s := range kucoinSymbols {
+		inst := Instrument{
+			Exchange:    "KUCOIN",
+			InstId:      s.Symbol,
+			MinQty:      parseFloat(s.BaseMinSize),
+			MaxQty:      parseFloat(s.BaseMaxSize),
+			MinNotional: parseFloat(s.MinFunds),
+		}
+		inst.TickSize, inst.priceDecimals = parseIncrement(s.PriceIncrement)
+		inst.StepSize, inst.qtyDecimals = parseIncrement(s.BaseIncrement)
+		loaded["KUCOIN"][inst.InstId] = inst
+	}
+
+	mu.Lock()
+	instruments = loaded
+	mu.Unlock()
+	return nil
+}
+
+// Get returns the rules of instId (e.g. BTC-USDT) on exchange.
+func Get(exchange, instId string) (Instrument, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	inst, ok := instruments[exchange][instId]
+	if !ok {
+		return Instrument{}, fmt.Errorf("no instrument metadata for %s on %s", instId, exchange)
+	}
+	return inst, nil
+}
+
+// BySymbol returns the rules of a symbol written without a separator, as
+// Binance does (e.g. BTCUSDT).
+func BySymbol(exchange, symbol string) (Instrument, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	for instId, inst := range instruments[exchange] {
+		if strings.ReplaceAll(instId, "-", "") == symbol {
+			return inst, true
+		}
+	}
+	return Instrument{}, false
+}
+
+// RoundQty rounds a quantity down to the lot size.
+func (i Instrument) RoundQty(qty float64) float64 {
+	return floorTo(qty, i.StepSize)
+}
+
+// RoundPrice rounds a price to the tick size, down for buys and up for sells,
+// so a rounded limit never trades worse than requested.
+func (i Instrument) RoundPrice(price float64, side string) float64 {
+	if i.TickSize == 0 {
+		return price
+	}
+	if strings.EqualFold(side, "sell") {
+		return math.Ceil(price/i.TickSize-1e-9) * i.TickSize
+	}
+	return floorTo(price, i.TickSize)
+}
+
+func (i Instrument) FormatQty(qty float64) string {
+	return strconv.FormatFloat(qty, 'f', i.qtyDecimals, 64)
+}
+
+func (i Instrument) FormatPrice(price float64) string {
+	return strconv.FormatFloat(price, 'f', i.priceDecimals, 64)
+}
+
+// Validate checks a rounded order against the size limits. The notional is
+// checked at price, or for a market order, which has none, at reference, the
+// best price on the side of the book it takes. It is skipped when both are
+// zero.
+func (i Instrument) Validate(price, qty, reference float64) error {
+	if qty <= 0 {
+		return fmt.Errorf("%s %s: quantity %s rounds to zero", i.Exchange, i.InstId, i.FormatQty(qty))
+	}
+	if qty < i.MinQty {
+		return fmt.Errorf("%s %s: quantity %s below minimum %g", i.Exchange, i.InstId, i.FormatQty(qty), i.MinQty)
+	}
+	if i.MaxQty > 0 && qty > i.MaxQty {
+		return fmt.Errorf("%s %s: quantity %s above maximum %g", i.Exchange, i.InstId, i.FormatQty(qty), i.MaxQty)
+	}
+	if price == 0 {
+		price = reference
+	}
+	if price > 0 && price*qty < i.MinNotional {
+		return fmt.Errorf("%s %s: notional %.8g below minimum %g", i.Exchange, i.InstId, price*qty, i.MinNotional)
+	}
+	return nil
+}
+
+// floorTo rounds value down to a multiple of step, tolerating float noise
+// such as 0.3/0.1 = 2.9999999999999996.
+func floorTo(value, step float64) float64 {
+	if step == 0 {
+		return value
+	}
+	return math.Floor(value/step+1e-9) * step
+}
+
+// parseIncrement returns an increment such as "0.00100000" and its number of decimals.
+func parseIncrement(s string) (float64, int) {
+	decimals := 0
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		decimals = len(strings.TrimRight(s[i+1:], "0"))
+	}
+	return parseFloat(s), decimals
+}
+
+func parseFloat(s string) float64 {
+	v, _ := strconv.ParseFloat(s, 64)
+	return v
+}
@@ -0,0 +1,91 @@
+package instrument
+
+import (
+	"strings"
+	"testing"
+)
+
+func testInstrument() Instrument {
+	i := Instrument{Exchange: "BINANCE", InstId: "DOGE-USDT", MinQty: 1, MaxQty: 1000, MinNotional: 5}
+	i.TickSize, i.priceDecimals = parseIncrement("0.00001000")
+	i.StepSize, i.qtyDecimals = parseIncrement("0.10000000")
+	return i
+}
+
+func TestParseIncrement(t *testing.T) {
+	tests := []struct {
+		in       string
+		value    float64
+		decimals int
+	}{
+		{"0.00100000", 0.001, 3},
+		{"1.00000000", 1, 0},
+		{"10", 10, 0},
+		{"0.5", 0.5, 1},
+	}
+	for _, tt := range tests {
+		value, decimals := parseIncrement(tt.in)
+		if value != tt.value || decimals != tt.decimals {
+			t.Errorf("parseIncrement(%q) = %v, %d, want %v, %d", tt.in, value, decimals, tt.value, tt.decimals)
+		}
+	}
+}
+
+func TestRounding(t *testing.T) {
+	i := testInstrument()
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"qty rounds down to the step", i.FormatQty(i.RoundQty(12.39)), "12.3"},
+		{"qty already on a step survives float noise", i.FormatQty(i.RoundQty(0.3)), "0.3"},
+		{"qty below one step", i.FormatQty(i.RoundQty(0.09)), "0.0"},
+		{"buy price rounds down", i.FormatPrice(i.RoundPrice(0.123456, "buy")), "0.12345"},
+		{"sell price rounds up", i.FormatPrice(i.RoundPrice(0.123451, "SELL")), "0.12346"},
+		{"price on a tick is kept", i.FormatPrice(i.RoundPrice(0.12345, "sell")), "0.12345"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, tt.got, tt.want)
+		}
+	}
+
+	var zero Instrument
+	if got := zero.RoundQty(1.2345); got != 1.2345 {
+		t.Errorf("RoundQty without a step = %v, want 1.2345", got)
+	}
+	if got := zero.RoundPrice(1.2345, "buy"); got != 1.2345 {
+		t.Errorf("RoundPrice without a tick = %v, want 1.2345", got)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	i := testInstrument()
+	tests := []struct {
+		name                  string
+		price, qty, reference float64
+		err                   string // Substring of the error, empty for none
+	}{
+		{"valid limit", 0.1, 100, 0, ""},
+		{"zero quantity", 0.1, 0, 0, "rounds to zero"},
+		{"below minimum quantity", 10, 0.5, 0, "below minimum 1"},
+		{"above maximum quantity", 0.1, 1001, 0, "above maximum 1000"},
+		{"limit below minimum notional", 0.1, 40, 0, "notional 4 below minimum 5"},
+		{"market checked at the reference", 0, 40, 0.1, "notional 4 below minimum 5"},
+		{"market above minimum notional", 0, 60, 0.1, ""},
+		{"limit price wins over the reference", 0.2, 40, 0.1, ""},
+		{"market without a reference", 0, 40, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := i.Validate(tt.price, tt.qty, tt.reference)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("Validate(%v, %v, %v) = %v, want nil", tt.price, tt.qty, tt.reference, err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("Validate(%v, %v, %v) = %v, want %q", tt.price, tt.qty, tt.reference, err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"arbitrage/exchange"
+	"arbitrage/instrument"
+	"arbitrage/order"
+	"arbitrage/rest"
+	"arbitrage/utils"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// The two legs of a trade go to different exchanges and can execute
+// differently. A leg that comes up short is retried within a budget; whatever
+// is still one-sided afterwards is unwound at market, and every such case is
+// recorded in the database.
+var (
+	legRetries    = 2
+	legRetryDelay = 500 * time.Millisecond
+)
+
+// tradeMu serializes opening and closing, so that quotes arriving while a
+// trade is in flight cannot start another one on the same position.
+var tradeMu sync.Mutex
+
+// legFill is what executed of one leg over all its attempts.
+type legFill struct {
+	qty      float64
+	price    float64 // Average over the attempts
+	fee      float64
+	feeAsset string
+	orderId  string // Of the last attempt that executed
+	err      error  // Why the leg is short of its quantity
+}
+
+// fillLeg places a leg of qty and retries whatever did not execute within
+// the retry budget.
+func fillLeg(name, market, instId string, qty float64, place func(qty float64) (order.OrderResult, error)) legFill {
+	var fill legFill
+attempts:
+	for attempt := 0; attempt <= legRetries; attempt++ {
+		remaining := qty - fill.qty
+		if dust(market, instId, remaining) {
+			fill.err = nil
+			return fill
+		}
+		if attempt > 0 {
+			log.Printf("%s: retrying %f %s (%d/%d)", name, remaining, instId, attempt, legRetries)
+			clk.Sleep(legRetryDelay)
+		}
+
+		result, err := place(remaining)
+		var fillErr *order.FillError
+		if err != nil && !errors.As(err, &fillErr) {
+			result = order.OrderResult{}
+		}
+		if result.ExecutedQty > 0 {
+			fill.price = (fill.price*fill.qty + result.AvgPrice*result.ExecutedQty) / (fill.qty + result.ExecutedQty)
+			fill.qty += result.ExecutedQty
+			fill.fee += result.Fee
+			fill.feeAsset = result.FeeAsset
+			fill.orderId = result.OrderId
+		}
+		if err != nil {
+			log.Printf("%s: %v", name, err)
+			fill.err = err
+			switch rest.Classify(err) {
+			case rest.InsufficientBalance, rest.Precision, rest.Auth:
+				break attempts // Another attempt would be refused the same way
+			}
+		}
+	}
+	if dust(market, instId, qty-fill.qty) {
+		fill.err = nil
+	} else if fill.err == nil {
+		fill.err = fmt.Errorf("%s: %f of %f executed", name, fill.qty, qty)
+	}
+	return fill
+}
+
+// dust reports whether qty is too small to trade on market.
+func dust(market, instId string, qty float64) bool {
+	if qty <= 1e-12 {
+		return true
+	}
+	inst, err := instrument.Get(market, instId)
+	if err != nil {
+		return false
+	}
+	return inst.RoundQty(qty) == 0 || inst.RoundQty(qty) < inst.MinQty
+}
+
+// recordOutcome stores how a one-sided execution was resolved.
+func recordOutcome(db *leveldb.DB, outcome utils.LegOutcome) {
+	outcome.Time = clk.Now()
+	log.Printf("legging %s %s: %s (bought %f, shorted %f, unwound %f) %s",
+		outcome.Action, outcome.InstId, outcome.Outcome, outcome.BuyQty, outcome.SellQty, outcome.Unwound, outcome.Error)
+	if err := utils.SaveLegOutcome(db, outcome); err != nil {
+		log.Println("Save error:", err)
+	}
+}
+
+// unwind trades qty back at market with the retry budget and returns how much
+// it did trade.
+func unwind(name, market, instId string, qty float64, place func(qty float64) (order.OrderResult, error)) (float64, error) {
+	if dust(market, instId, qty) {
+		return 0, nil
+	}
+	fill := fillLeg(name, market, instId, qty, place)
+	return fill.qty, fill.err
+}
+
+// returnCollateral moves the USDT left in the margin wallet of ex, up to
+// CAPITAL, back to spot after a short was unwound. Fees may have eaten into it.
+func returnCollateral(ex exchange.Exchange) {
+	balances, err := ex.Balances()
+	if err != nil {
+		log.Println(ex.Name(), "balance error:", err)
+		return
+	}
+	for _, b := range balances {
+		if b.Wallet == exchange.MARGIN && b.Currency == "USDT" && b.Balance > 0 {
+			if _, err := ex.Transfer("USDT", exchange.MARGIN, exchange.SPOT, math.Min(b.Balance, CAPITAL)); err != nil {
+				log.Println(ex.Name(), "transfer error:", err)
+			}
+			return
+		}
+	}
+}
+
+// errorText joins the errors of a trade for the record.
+func errorText(errs ...error) string {
+	var text string
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+		if text != "" {
+			text += "; "
+		}
+		text += err.Error()
+	}
+	return text
+}
+
+// open and reverse place one leg of instId on ex for fillLeg.
+func open(ex exchange.Exchange, marketType, instId string) func(qty float64) (order.OrderResult, error) {
+	return func(qty float64) (order.OrderResult, error) {
+		return ex.Order(marketType, instId, qty)
+	}
+}
+
+func reverse(ex exchange.Exchange, marketType, instId string) func(qty float64) (order.OrderResult, error) {
+	return func(qty float64) (order.OrderResult, error) {
+		return ex.Reverse(marketType, instId, qty)
+	}
+}
+
+// openAt and reverseAt are like open and reverse but place IOC limit orders
+// at price, so that the leg executes no worse than the last level the trade
+// was computed to reach. Whatever is left over is cancelled by the exchange.
+func openAt(ex exchange.Exchange, marketType, instId string, price float64) func(qty float64) (order.OrderResult, error) {
+	side := order.Buy
+	if marketType == exchange.MARGIN {
+		side = order.Sell
+	}
+	return func(qty float64) (order.OrderResult, error) {
+		return ex.Limit(marketType, side, instId, price, qty, order.IOC)
+	}
+}
+
+func reverseAt(ex exchange.Exchange, marketType, instId string, price float64) func(qty float64) (order.OrderResult, error) {
+	side := order.Sell
+	if marketType == exchange.MARGIN {
+		side = order.Buy
+	}
+	return func(qty float64) (order.OrderResult, error) {
+		return ex.Limit(marketType, side, instId, price, qty, order.IOC)
+	}
+}
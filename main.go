@@ -1,12 +1,26 @@
 package main
 
 import (
+	"arbitrage/clock"
+	"arbitrage/exchange"
+	"arbitrage/instrument"
 	"arbitrage/order"
+	"arbitrage/orderbook"
+	"arbitrage/recorder"
+	"arbitrage/rest"
+	"arbitrage/sim"
 	"arbitrage/transfer"
+	"arbitrage/universe"
 	"arbitrage/utils"
+	"arbitrage/workflow"
 	"context"
+	_ "expvar" // Serves /debug/vars on the metrics address
+	"flag"
+	"fmt"
 	"log"
+	"maps"
 	"math"
+	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
@@ -17,33 +31,104 @@ import (
 	"github.com/syndtr/goleveldb/leveldb"
 )
 
-type TickerGeneral struct {
-	InstId string `json:"instId"`
-	Price  string `json:"price"`
-	Market string `json:"Market"`
-	Size string `json:"size"`
-}
-
 type PriceInfo struct {
-	Price  float64
-	Market string
-	Size   float64
+	Bid     float64
+	BidSize float64
+	Ask     float64
+	AskSize float64
+	Market  string
+	Time    int64
 }
 
 const CAPITAL = 2000
 
+// clk is the time source of the strategy; a replay swaps in a virtual clock.
+var clk clock.Clock = clock.Real{}
+
+// How a position is closed: by trading both legs back once the quotes
+// converge, or by delivering the coins bought to repay the short.
+const (
+	settleTrade    = "trade"
+	settleTransfer = "transfer"
+	settleUsage    = "how positions are closed: trade, trading both legs back once the quotes converge, or transfer, shipping the coins bought to repay the short"
+)
+
+var (
+	settleMode = settleTrade
+	// runCtx bounds the workflows the strategy starts.
+	runCtx = context.Background()
+)
+
 func main() {
-	tickers := make(chan TickerGeneral)
+	if len(os.Args) > 1 && os.Args[1] == "backtest" {
+		runBacktest(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "workflows" {
+		runWorkflows(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "addresses" {
+		runAddresses(os.Args[2:])
+		return
+	}
+
+	recordDir := flag.String("record", "", "directory to record market data to (disabled when empty)")
+	replayDir := flag.String("replay", "", "replay a recording directory instead of trading live")
+	speed := flag.Float64("speed", 0, "replay speed: 1 is the original pace, 0 as fast as possible")
+	paper := flag.Bool("paper", false, "trade against simulated wallets on live market data")
+	paperBalance := flag.Float64("paper-balance", 2*CAPITAL, "USDT in the spot wallet of each simulated exchange when paper trading starts")
+	simCfg := simFlags(flag.CommandLine)
+	flag.IntVar(&legRetries, "leg-retries", legRetries, "times a leg that did not fully execute is retried before the other leg is unwound")
+	flag.DurationVar(&legRetryDelay, "leg-retry-delay", legRetryDelay, "delay between retries of a leg")
+	httpCfg := rest.DefaultConfig
+	flag.DurationVar(&httpCfg.Timeout, "http-timeout", httpCfg.Timeout, "timeout of REST requests to the exchanges, reading the response included")
+	flag.DurationVar(&httpCfg.DialTimeout, "http-dial-timeout", httpCfg.DialTimeout, "timeout of opening a connection to an exchange")
+	flag.IntVar(&httpCfg.MaxIdleConnsPerHost, "http-idle-conns", httpCfg.MaxIdleConnsPerHost, "idle connections kept open to each exchange")
+	warmConns := flag.Int("warm-conns", 4, "connections opened to each exchange ahead of the first order")
+	flag.DurationVar(&httpCfg.RecvWindow, "recv-window", httpCfg.RecvWindow, "how late a signed Binance request may arrive before it is rejected, at most 1m")
+	clockSync := flag.Duration("clock-sync", time.Minute, "interval between samples of the exchanges' server time")
+	metricsAddr := flag.String("metrics", "", "address to serve metrics on at /debug/vars (disabled when empty)")
+	flag.StringVar(&settleMode, "settle", settleMode, settleUsage)
+	flag.Parse()
+	rest.Configure(httpCfg)
+	if settleMode != settleTrade && settleMode != settleTransfer {
+		log.Fatal("-settle must be trade or transfer")
+	}
+
+	if *replayDir != "" {
+		runReplay(*replayDir, *speed)
+		return
+	}
+
+	tickers := make(chan exchange.TickerGeneral)
 
 	ctx, cancel := context.WithCancel(context.Background())
+	runCtx = ctx
 	wg := &sync.WaitGroup{}
-    
-	// res, err := transfer.GetAllMarginAllowed()
-	// if err != nil {
-	// 	log.Fatalln(err)
-	// }
-	// log.Fatalln(res)
-	db, err := utils.Database("orderdb")
+
+	if !*paper && *warmConns > 0 {
+		go rest.KeepWarm(ctx, *warmConns)
+	}
+	if !*paper {
+		// Signed requests are stamped with the exchanges' time, not ours
+		if err := rest.SyncClocks(ctx); err != nil {
+			log.Println("clock sync:", err)
+		}
+		go rest.KeepSynced(ctx, *clockSync)
+	}
+	if *metricsAddr != "" {
+		go func() {
+			log.Println("metrics:", http.ListenAndServe(*metricsAddr, nil))
+		}()
+	}
+
+	// Paper trading keeps its orders and wallets apart from the real ones
+	dbPath := "orderdb"
+	if *paper {
+		dbPath = "paperdb"
+	}
+	db, err := utils.Database(dbPath)
 	if err != nil {
 		log.Fatal("Failed to open LevelDB:", err)
 	}
@@ -51,20 +136,101 @@ func main() {
 
 	orders, isOpen, err := utils.FindOpenOrders(db)
 	if err != nil {
-		log.Fatal("Failed to get orders:", err)
+		if !*paper || err != leveldb.ErrNotFound {
+			log.Fatal("Failed to get orders:", err)
+		}
+		// A fresh paper database has no orders yet
+		orders, isOpen = utils.OrderData{}, false
 	}
 
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		go Kucoin(ctx, tickers)
-	}()
+	// Paper workflows move simulated funds and are kept apart with them
+	workflow.Use(db)
+	if !*paper {
+		// Orders sent before a crash may have filled without being recorded
+		order.UseJournal(db)
+		// Withdrawals only go to approved addresses, within the limits
+		transfer.UseAddressBook(db)
+		results, err := order.Reconcile()
+		if err != nil {
+			log.Fatal("Failed to reconcile pending orders:", err)
+		}
+		for _, result := range results {
+			reportStray("reconciled", result, orders)
+		}
+	}
 
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		binance(ctx, tickers)
-	}()
+	instIds, err := universe.Build("USDT")
+	if err != nil {
+		log.Fatal("Failed to build symbol universe:", err)
+	}
+	if err := instrument.Load(); err != nil {
+		log.Fatal("Failed to load instrument metadata:", err)
+	}
+
+	if !*paper {
+		// Every order this program places is IOC, so any of its orders still
+		// resting was orphaned by a previous run
+		for _, name := range []string{"BINANCE", "KUCOIN"} {
+			cancelled, err := order.CancelAll(name, true)
+			if err != nil {
+				log.Println(name, "orphan sweep:", err)
+			}
+			for _, result := range cancelled {
+				reportStray("cancelled orphaned", result, orders)
+			}
+		}
+	}
+
+	if *paper {
+		for _, feed := range []exchange.Exchange{exchange.NewBinance(instIds), exchange.NewKucoin(instIds)} {
+			ex := sim.NewPaper(feed, *simCfg)
+			restored, err := ex.Persist(db)
+			if err != nil {
+				log.Fatal("Failed to load paper wallets:", err)
+			}
+			if !restored {
+				ex.Deposit(exchange.SPOT, "USDT", *paperBalance)
+			}
+			exchange.Register(ex)
+		}
+		log.Println("paper trading, no orders reach the exchanges")
+	} else {
+		exchange.Register(exchange.NewBinance(instIds))
+		exchange.Register(exchange.NewKucoin(instIds))
+	}
+
+	for _, ex := range exchange.All() {
+		wg.Add(1)
+		go func(ex exchange.Exchange) {
+			defer wg.Done()
+			ex.Stream(ctx, tickers)
+		}(ex)
+	}
+
+	// Fund movements interrupted by a restart continue where they stopped
+	if err := workflow.Resume(ctx); err != nil {
+		log.Println("workflow resume:", err)
+	}
+
+	// Private streams push fills and balance changes; paper exchanges have none
+	for _, ex := range exchange.All() {
+		if us, ok := ex.(exchange.UserStreamer); ok {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				us.UserStream(ctx)
+			}()
+		}
+	}
+
+	var rec *recorder.Recorder
+	if *recordDir != "" {
+		rec, err = recorder.New(*recordDir)
+		if err != nil {
+			log.Fatal("Failed to start recorder:", err)
+		}
+		defer rec.Close()
+	}
 
 	// Handle interrupt signals for graceful shutdown
 	c := make(chan os.Signal, 1)
@@ -75,297 +241,475 @@ func main() {
 		cancel()
 	}()
 
+	// Process tickers
+	p := &processor{db: db, orders: orders, isOpen: isOpen, rec: rec}
+	go p.run(tickers)
+
+	// Wait for the WebSocket goroutines to finish
+	wg.Wait()
+	log.Println("shutting down")
+}
+
+// processor keeps the latest quote of every symbol on every market and runs
+// the strategy on each update.
+type processor struct {
+	db     *leveldb.DB
+	orders utils.OrderData
+	isOpen bool
+	rec    *recorder.Recorder
+
+	// inline runs the closing check before the opening check instead of
+	// concurrently, so that a replay takes the same decisions every time.
+	inline bool
+	// virtual, when set, is moved to the receive time of every quote before
+	// the strategy sees it.
+	virtual *clock.Virtual
+	// afterTick, when set, runs once the strategy has handled a quote.
+	afterTick func(exchange.TickerGeneral)
+}
+
+// run processes tickers until the channel is closed.
+func (p *processor) run(tickers chan exchange.TickerGeneral) {
 	// Store latest prices from each exchange
 	prices := make(map[string]map[string]PriceInfo) // InstId -> Market -> PriceInfo
 	var mu sync.Mutex
 
-	// Process tickers
-	go func() {
-		for ticker := range tickers {
-			price, err := strconv.ParseFloat(ticker.Price, 64)
-			if err != nil {
-				log.Printf("invalid price %s for %s: %v", ticker.Price, ticker.InstId, err)
-				continue
-			}
-			size, err := strconv.ParseFloat(ticker.Size, 64)
-			if err != nil {
-				log.Printf("invalid size %s for %s: %v", ticker.Size, ticker.InstId, err)
-				continue
-			}
+	for ticker := range tickers {
+		if p.rec != nil {
+			p.rec.Record(ticker)
+		}
+		if p.virtual != nil {
+			p.virtual.Set(time.UnixMicro(ticker.Received))
+		}
 
+		if ticker.Stale {
+			// The venue disconnected, none of its quotes can be traded on
+			mu.Lock()
+			for _, infos := range prices {
+				delete(infos, ticker.Market)
+			}
+			mu.Unlock()
+			log.Println("quotes invalidated for", ticker.Market)
+		} else if info, err := parsePriceInfo(ticker); err != nil {
+			log.Printf("invalid quote for %s on %s: %v", ticker.InstId, ticker.Market, err)
+		} else {
 			mu.Lock()
 			if _, exists := prices[ticker.InstId]; !exists {
 				prices[ticker.InstId] = make(map[string]PriceInfo)
 			}
-			prices[ticker.InstId][ticker.Market] = PriceInfo{Price: price, Market: ticker.Market, Size: size}
+			prices[ticker.InstId][ticker.Market] = info
 
 			// Check for arbitrage opportunities
 			if len(prices[ticker.InstId]) > 1 {
-				go CheckifOrderOpen(db, orders, &isOpen, ticker.InstId, prices[ticker.InstId])
-				checkArbitrage(&isOpen, db, &orders, ticker.InstId, prices[ticker.InstId])
+				if p.inline {
+					CheckifOrderOpen(p.db, &p.orders, &p.isOpen, ticker.InstId, prices[ticker.InstId])
+				} else {
+					// The goroutine outlives the lock, so it gets its own copy of the quotes
+					go CheckifOrderOpen(p.db, &p.orders, &p.isOpen, ticker.InstId, maps.Clone(prices[ticker.InstId]))
+				}
+				checkArbitrage(&p.isOpen, p.db, &p.orders, ticker.InstId, prices[ticker.InstId])
 			}
 			mu.Unlock()
 		}
-	}()
 
-	// Wait for the WebSocket goroutines to finish
-	wg.Wait()
-	log.Println("shutting down")
+		if p.afterTick != nil {
+			p.afterTick(ticker)
+		}
+	}
 }
 
-func CheckifOrderOpen(db *leveldb.DB, orders utils.OrderData, isOpen *bool, instId string, priceInfos map[string]PriceInfo) {
-	var binance, kucoin PriceInfo
+func parsePriceInfo(ticker exchange.TickerGeneral) (PriceInfo, error) {
+	info := PriceInfo{Market: ticker.Market, Time: ticker.Time}
+	fields := []struct {
+		value string
+		dest  *float64
+	}{
+		{ticker.BidPrice, &info.Bid},
+		{ticker.BidSize, &info.BidSize},
+		{ticker.AskPrice, &info.Ask},
+		{ticker.AskSize, &info.AskSize},
+	}
+	for _, f := range fields {
+		v, err := strconv.ParseFloat(f.value, 64)
+		if err != nil {
+			return PriceInfo{}, err
+		}
+		*f.dest = v
+	}
+	return info, nil
+}
+
+// reportStray logs an order found at startup and warns when it executed
+// without being part of the saved position.
+func reportStray(what string, result order.OrderResult, orders utils.OrderData) {
+	log.Printf("%s %s order %s %s %s: %s, executed %f of %f", what, result.Exchange, result.ClientId, result.Side, result.InstId, result.Status, result.ExecutedQty, result.Quantity)
+	if result.ExecutedQty > 0 && result.OrderId != orders.BuyOrderId && result.OrderId != orders.SellOrderId {
+		log.Printf("WARNING: %s order %s executed but is not part of the saved position, check it by hand", result.Exchange, result.OrderId)
+	}
+}
+
+func CheckifOrderOpen(db *leveldb.DB, orders *utils.OrderData, isOpen *bool, instId string, priceInfos map[string]PriceInfo) {
 	base := strings.Split(instId, "-")[0]
-	if base != orders.Coin {
+	if !*isOpen || base != orders.Coin {
+		return
+	}
+	if settleMode == settleTransfer && settleByTransfer(db, orders, isOpen, instId) {
 		return
 	}
 
-	// Channels for tracking success of reverse operations
-	buyReverseSuccess := make(chan bool)
-	sellReverseSuccess := make(chan bool)
+	// Close once the long can be sold for at least what buying back the short costs
+	bought, ok := priceInfos[orders.BuyMarket]
+	if !ok || bought.Bid == 0 {
+		return
+	}
+	sold, ok := priceInfos[orders.SellMarket]
+	if !ok || sold.Ask == 0 {
+		return
+	}
+	if bought.Bid < sold.Ask {
+		return
+	}
+
+	if !tradeMu.TryLock() {
+		return // Already closing
+	}
+	defer tradeMu.Unlock()
+	if !*isOpen || base != orders.Coin {
+		return
+	}
+	position := *orders
 
-	for _, priceInfo := range priceInfos {
-		if priceInfo.Market == "BINANCE" {
-			binance = priceInfo
+	buyEx, err := exchange.Get(position.BuyMarket)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	sellEx, err := exchange.Get(position.SellMarket)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	var soldBack, boughtBack legFill
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		if !dust(position.BuyMarket, instId, position.Amount) {
+			soldBack = fillLeg(buyEx.Name()+" sell", position.BuyMarket, instId, position.Amount, reverseAt(buyEx, "SPOT", instId, bought.Bid))
 		}
-		if priceInfo.Market == "KUCOIN" {
-			kucoin = priceInfo
+	}()
+
+	go func() {
+		defer wg.Done()
+		if !dust(position.SellMarket, instId, position.SellAmount) {
+			boughtBack = fillLeg(sellEx.Name()+" cover", position.SellMarket, instId, position.SellAmount, reverseAt(sellEx, "MARGIN", instId, sold.Ask))
 		}
-		if binance.Price == 0 || kucoin.Price == 0 {
-			return
+	}()
+
+	wg.Wait()
+
+	long := position.Amount - soldBack.qty
+	short := position.SellAmount - boughtBack.qty
+	if boughtBack.qty > 0 {
+		clk.Sleep(1 * time.Second)
+		if _, err := sellEx.RepayLoan(base, boughtBack.qty); err != nil {
+			log.Println(sellEx.Name(), "repay error:", err)
 		}
+	}
 
-		if orders.BuyMarket == "BINANCE" {
-			if binance.Price >= kucoin.Price {
-				var wg sync.WaitGroup
-				wg.Add(2)
-
-				go func() {
-					defer wg.Done()
-					err := order.BinanceReverse("SPOT", orders.Amount, instId)
-					if err != nil {
-						log.Fatalf("Binance failed to reverse sell: %f %s", orders.Amount, instId)
-						buyReverseSuccess <- false
-					} else {
-						buyReverseSuccess <- true
-					}
-				}()
-
-				go func() {
-					defer wg.Done()
-					err := order.KucoinReverse("MARGIN", instId, orders.Amount)
-					if err != nil {
-						log.Fatalf("Kucoin failed to reverse buy: %f %s", orders.Amount, instId)
-						sellReverseSuccess <- false
-					} else {
-						sellReverseSuccess <- true
-					}
-				}()
-
-				wg.Wait()
-
-				if <-buyReverseSuccess && <-sellReverseSuccess {
-					time.Sleep(1 * time.Second)
-					transfer.KucoinRepayLoan(base, orders.Amount)
-					time.Sleep(1 * time.Second)
-					_, err := transfer.KucoinMargin2spot("USDT", CAPITAL)
-					if err != nil {
-						log.Fatalln("Kucoin transfer error:", err)
-					}
-					err = utils.SaveOrders(db, "", "", "", 0, 0, 0)
-					if err != nil {
-						log.Fatalln("Save error:", err)
-					} else {
-						log.Fatalln("Save error:", err)
-					}
-				}
-			}
-		} else {
-			if kucoin.Price >= binance.Price {
-				var wg sync.WaitGroup
-				wg.Add(2)
-
-				go func() {
-					defer wg.Done()
-					err := order.KucoinReverse("SPOT", instId, orders.Amount)
-					if err != nil {
-						log.Fatalf("Kucoin failed to reverse sell: %f %s", orders.Amount, instId)
-						buyReverseSuccess <- false
-					} else {
-						buyReverseSuccess <- true
-					}
-				}()
-
-				go func() {
-					defer wg.Done()
-					err := order.BinanceReverse("MARGIN", orders.Amount, instId)
-					if err != nil {
-						log.Fatalf("Binance failed to reverse buy: %f %s", orders.Amount, instId)
-						sellReverseSuccess <- false
-					} else {
-						sellReverseSuccess <- true
-					}
-				}()
-
-				wg.Wait()
-
-				if <-buyReverseSuccess && <-sellReverseSuccess {
-					time.Sleep(1 * time.Second)
-					transfer.BinanceRepayMarginLoan(base, orders.Amount)
-					time.Sleep(1 * time.Second)
-					_, err := transfer.BinanceMargin2Spot("USDT", CAPITAL)
-					if err != nil {
-						log.Fatalln("Transfer error:", err)
-					}
-					err = utils.SaveOrders(db, "", "", "", 0, 0, 0)
-					if err != nil {
-						log.Fatalln("Save error:", err)
-					}else {
-						log.Fatalln("Save error:", err)
-					}
-				}
-			}
+	if soldBack.err != nil || boughtBack.err != nil {
+		// Re-open whichever side closed further, so that what stays open is hedged
+		outcome := utils.LegOutcome{
+			Action:     "close",
+			InstId:     instId,
+			BuyMarket:  position.BuyMarket,
+			SellMarket: position.SellMarket,
+			BuyQty:     soldBack.qty,
+			SellQty:    boughtBack.qty,
+			Outcome:    "hedged, close retried",
+		}
+		var unwound float64
+		var unwindErr error
+		if long < short {
+			unwound, unwindErr = unwind(buyEx.Name()+" rebuy", position.BuyMarket, instId, short-long, open(buyEx, "SPOT", instId))
+			long += unwound
+		} else if short < long {
+			unwound, unwindErr = unwind(sellEx.Name()+" reshort", position.SellMarket, instId, long-short, open(sellEx, "MARGIN", instId))
+			short += unwound
 		}
+		outcome.Unwound = unwound
+		outcome.Error = errorText(soldBack.err, boughtBack.err, unwindErr)
+		if unwindErr != nil {
+			outcome.Outcome = "unwind failed"
+		}
+		recordOutcome(db, outcome)
+	}
+
+	if !dust(position.BuyMarket, instId, long) || !dust(position.SellMarket, instId, short) {
+		// Keep the rest open; a later quote closes it
+		orders.Amount, orders.SellAmount = long, short
+		if err := utils.SaveOrders(db, *orders); err != nil {
+			log.Println("Save error:", err)
+		}
+		return
+	}
+
+	clk.Sleep(1 * time.Second)
+	if _, err := sellEx.Transfer("USDT", exchange.MARGIN, exchange.SPOT, CAPITAL); err != nil {
+		log.Println(sellEx.Name(), "transfer error:", err)
 	}
+	if err := utils.SaveOrders(db, utils.OrderData{}); err != nil {
+		log.Println("Save error:", err)
+	}
+	*isOpen = false
+	log.Println("closed", position.BuyMarket, position.SellMarket, instId, position.Amount, position.SellAmount)
+}
+
+// settleByTransfer closes the position by delivery rather than on the
+// market: a rebalance workflow ships the coins bought to the exchange they
+// were shorted on, repays the loan with them and brings the collateral back.
+// The position is handed over once both legs are confirmed; the workflow is
+// persisted and resumes after a restart. It reports whether the position was
+// handed over; one that was not, such as one left with a single leg, is
+// closed on the market instead.
+func settleByTransfer(db *leveldb.DB, orders *utils.OrderData, isOpen *bool, instId string) bool {
+	if !tradeMu.TryLock() {
+		return true // Already closing
+	}
+	defer tradeMu.Unlock()
+	base := strings.Split(instId, "-")[0]
+	if !*isOpen || base != orders.Coin {
+		return true
+	}
+	position := *orders
+	if dust(position.BuyMarket, instId, position.Amount) || dust(position.SellMarket, instId, position.SellAmount) {
+		return false
+	}
+
+	id, err := workflow.Rebalance(runCtx, position.BuyMarket, position.SellMarket, base, position.Amount, CAPITAL)
+	if err != nil {
+		log.Println("rebalance error:", err)
+		return false
+	}
+	if err := utils.SaveOrders(db, utils.OrderData{}); err != nil {
+		log.Println("Save error:", err)
+	}
+	*isOpen = false
+	log.Println("settling", position.BuyMarket, position.SellMarket, instId, position.Amount, position.SellAmount, "by transfer in workflow", id)
+	return true
 }
 
 func checkArbitrage(isOpen *bool, db *leveldb.DB, orders *utils.OrderData, instId string, priceInfos map[string]PriceInfo) {
-	var minPrice, maxPrice PriceInfo
-	first := true
-	for _, priceInfo := range priceInfos {
-		if first {
-			minPrice = priceInfo
-			maxPrice = priceInfo
-			first = false
-		} else {
-			if priceInfo.Price < minPrice.Price {
-				minPrice = priceInfo
+	// Buy at the ask on one venue and sell at the bid on another, in every direction
+	var bestBuy, bestSell PriceInfo
+	var bestProfit, buyPrice, sellPrice, buyLimit, sellLimit, amount float64
+	for _, buy := range priceInfos {
+		for _, sell := range priceInfos {
+			if buy.Market == sell.Market || buy.Ask == 0 || sell.Bid == 0 {
+				continue
 			}
-			if priceInfo.Price > maxPrice.Price {
-				maxPrice = priceInfo
+			profit, bp, sp, bl, sl, qty := arbitrageProfit(instId, buy, sell)
+			if profit > bestProfit {
+				bestProfit, buyPrice, sellPrice, buyLimit, sellLimit, amount = profit, bp, sp, bl, sl, qty
+				bestBuy = buy
+				bestSell = sell
 			}
 		}
 	}
 
-	if minPrice.Market != maxPrice.Market {
-		fees := 0.001 // Assume 0.1% fees for each trade
-		coeficient := CAPITAL / (minPrice.Price + (fees * minPrice.Price))
+	if bestProfit > 0 {
+		// log.Println(bestBuy.Market, "to", bestSell.Market, instId, buyPrice, sellPrice, bestProfit)
+		makeOrders(isOpen, db, orders, instId, bestBuy.Market, bestSell.Market, buyPrice, sellPrice, buyLimit, sellLimit, amount)
+	}
+}
+
+// arbitrageProfit estimates the USDT profit of buying on buy and selling on sell,
+// along with the expected average fill prices, the worst price each side has
+// to reach and the quantity both sides can fill. Both local order books are walked when they are in sync, otherwise
+// only the top of book is used.
+func arbitrageProfit(instId string, buy, sell PriceInfo) (profit, buyPrice, sellPrice, buyLimit, sellLimit, amount float64) {
+	fees := 0.001 // Assume 0.1% fees for each trade
+
+	buyBook, sellBook := syncedBook(buy.Market, instId), syncedBook(sell.Market, instId)
+	if buyBook != nil && sellBook != nil {
+		_, amount = buyBook.Fill(orderbook.Ask, CAPITAL/(1+fees))
+		sellPrice, amount = sellBook.FillQuantity(orderbook.Bid, amount)
+		buyPrice, amount = buyBook.FillQuantity(orderbook.Ask, amount)
+		buyLimit, sellLimit = buyBook.WorstPrice(orderbook.Ask, amount), sellBook.WorstPrice(orderbook.Bid, amount)
+	} else {
+		buyPrice, sellPrice = buy.Ask, sell.Bid
+		buyLimit, sellLimit = buyPrice, sellPrice
+		coeficient := CAPITAL / (buyPrice + (fees * buyPrice))
 
 		// get the lowest size between ask and bid
-		lowestSize := math.Min(minPrice.Size, maxPrice.Size)
-		amount := math.Min(lowestSize, coeficient)
-		sellValue := amount * (maxPrice.Price - (fees * maxPrice.Price))
-		final := sellValue - 3 // Considered transfer fees to be $2 for coin and $1 for USDT
-		capital := amount * minPrice.Price
-		profit := final - capital
-	
-		if profit > 0 && minPrice.Market == "KUCOIN" && maxPrice.Market == "BINANCE" {
-			// log.Println("KUCOIN to BINANCE", instId, minPrice.Price, maxPrice.Price, capital, profit)
-			makeOrders(isOpen, db, orders, instId, "KUCOIN", minPrice.Price, maxPrice.Price)
-		}
+		lowestSize := math.Min(buy.AskSize, sell.BidSize)
+		amount = math.Min(lowestSize, coeficient)
+	}
+	if amount == 0 {
+		return 0, 0, 0, 0, 0, 0
+	}
+
+	sellValue := amount * (sellPrice - (fees * sellPrice))
+	final := sellValue - 3 // Considered transfer fees to be $2 for coin and $1 for USDT
+	capital := amount * buyPrice
+	return final - capital, buyPrice, sellPrice, buyLimit, sellLimit, amount
+}
+
+// syncedBook returns the local order book of instId on market when it is in sync.
+func syncedBook(market, instId string) *orderbook.Book {
+	ex, err := exchange.Get(market)
+	if err != nil {
+		return nil
 	}
+	book := ex.Book(instId)
+	if book == nil || !book.Synced() {
+		return nil
+	}
+	return book
 }
 
-func makeOrders(isOpen *bool, db *leveldb.DB, orders *utils.OrderData, instId string, buyMarket string, minPrice, maxPrice float64) {
+// makeOrders opens a position of amount, the quantity arbitrageProfit found
+// both books can fill, bought on buyMarket and shorted on sellMarket. Each
+// leg is limited to the worst level it has to reach, buyLimit and sellLimit,
+// while minPrice and maxPrice are the average prices the trade was computed at.
+func makeOrders(isOpen *bool, db *leveldb.DB, orders *utils.OrderData, instId string, buyMarket, sellMarket string, minPrice, maxPrice, buyLimit, sellLimit, amount float64) {
 	if *isOpen {
-		time.Sleep(100 * time.Millisecond)
+		clk.Sleep(100 * time.Millisecond)
 		return
 	}
-	buyAmount := math.Floor(CAPITAL / minPrice)
-	if buyAmount < CAPITAL {
+	if math.Floor(CAPITAL/minPrice) < CAPITAL {
+		return // Only coins priced at most 1 USDT are traded
+	}
+	buyAmount := math.Floor(amount)
+	if buyAmount == 0 {
 		return
 	}
 	base := strings.Split(instId, "-")[0]
 
-	var wg sync.WaitGroup
-	buySuccess := make(chan bool)
-	sellSuccess := make(chan bool)
+	buyEx, err := exchange.Get(buyMarket)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	sellEx, err := exchange.Get(sellMarket)
+	if err != nil {
+		log.Println(err)
+		return
+	}
 
-	if buyMarket == "BINANCE" {
-		wg.Add(2)
+	if !tradeMu.TryLock() {
+		return
+	}
+	defer tradeMu.Unlock()
 
-		go func() {
-			defer wg.Done()
-			err := order.Binance("SPOT", buyAmount, instId)
-			if err != nil {
-				log.Fatalf("Failed to place Binance buy order: %v", err)
-				buySuccess <- false
-			} else {
-				buySuccess <- true
-			}
-		}()
+	var wg sync.WaitGroup
+	var bought, sold legFill
+	transferred := false
 
-		go func() {
-			defer wg.Done()
-			_, err := transfer.KucoinSpot2Margin("USDT", CAPITAL)
-			if err != nil {
-				log.Fatalln("Transfer error:", err)
-			}
-			err = order.Kucoin("MARGIN", instId, buyAmount)
-			if err != nil {
-				log.Fatalf("Failed to place Kucoin short order: %v", err)
-				sellSuccess <- false
-			} else {
-				sellSuccess <- true
-			}
-		}()
+	wg.Add(2)
 
-		wg.Wait()
+	go func() {
+		defer wg.Done()
+		bought = fillLeg(buyEx.Name()+" buy", buyMarket, instId, buyAmount, openAt(buyEx, "SPOT", instId, buyLimit))
+	}()
 
-		if <-buySuccess && <-sellSuccess {
-			time.Sleep(1 * time.Second)
-			*isOpen = true
-			orders.BuyMarket = "BINANCE"
-			orders.SellMarket = "KUCOIN"
-			orders.Amount = buyAmount
-			orders.Coin = base
-			err := utils.SaveOrders(db, "BINANCE", "KUCOIN", base, buyAmount, minPrice, maxPrice)
-			if err != nil {
-				log.Fatalln("Save error:", err)
-			}
+	go func() {
+		defer wg.Done()
+		_, err := sellEx.Transfer("USDT", exchange.SPOT, exchange.MARGIN, CAPITAL)
+		if err != nil {
+			sold.err = fmt.Errorf("%s transfer error: %v", sellEx.Name(), err)
+			return
 		}
-	} else {
-		wg.Add(2)
+		transferred = true
+		sold = fillLeg(sellEx.Name()+" short", sellMarket, instId, buyAmount, openAt(sellEx, "MARGIN", instId, sellLimit))
+	}()
 
-		go func() {
-			defer wg.Done()
-			err := order.Kucoin("SPOT", instId, buyAmount)
-			if err != nil {
-				log.Fatalf("Failed to place Kucoin buy order: %v", err)
-				buySuccess <- false
-			} else {
-				buySuccess <- true
-			}
-		}()
+	wg.Wait()
 
-		go func() {
-			defer wg.Done()
-			_, err := transfer.BinanceSpot2Margin("USDT", CAPITAL)
-			if err != nil {
-				log.Fatalln("Transfer error:", err)
-			}
-			err = order.Binance("MARGIN", buyAmount, "MARGIN")
-			if err != nil {
-				log.Fatalf("Failed to place Binance short order: %v", err)
-				sellSuccess <- false
-			} else {
-				sellSuccess <- true
+	long, short := bought.qty, sold.qty
+	if long == 0 && short == 0 {
+		// Neither leg executed, there is nothing to unwind
+		log.Println("not opened", instId+":", errorText(bought.err, sold.err))
+		if transferred {
+			returnCollateral(sellEx)
+		}
+		return
+	}
+	if bought.err != nil || sold.err != nil {
+		// Only what executed on both sides is hedged; trade the excess back
+		outcome := utils.LegOutcome{
+			Action:     "open",
+			InstId:     instId,
+			BuyMarket:  buyMarket,
+			SellMarket: sellMarket,
+			BuyQty:     long,
+			SellQty:    short,
+		}
+		var unwound float64
+		var unwindErr error
+		if long > short {
+			unwound, unwindErr = unwind(buyEx.Name()+" unwind", buyMarket, instId, long-short, reverse(buyEx, "SPOT", instId))
+			long -= unwound
+		} else if short > long {
+			unwound, unwindErr = unwind(sellEx.Name()+" unwind", sellMarket, instId, short-long, reverse(sellEx, "MARGIN", instId))
+			short -= unwound
+			if unwound > 0 {
+				if _, err := sellEx.RepayLoan(base, unwound); err != nil {
+					log.Println(sellEx.Name(), "repay error:", err)
+				}
 			}
-		}()
-
-		wg.Wait()
+		}
+		outcome.Unwound = unwound
+		outcome.Error = errorText(bought.err, sold.err, unwindErr)
+		switch {
+		case unwindErr != nil:
+			outcome.Outcome = "unwind failed"
+		case dust(buyMarket, instId, long) && dust(sellMarket, instId, short):
+			outcome.Outcome = "unwound"
+		default:
+			outcome.Outcome = "partially hedged"
+		}
+		recordOutcome(db, outcome)
+	}
 
-		if <-buySuccess && <-sellSuccess {
-			time.Sleep(1 * time.Second)
-			*isOpen = true
-			orders.BuyMarket = "KUCOIN"
-			orders.SellMarket = "BINANCE"
-			orders.Amount = buyAmount
-			orders.Coin = base
-			err := utils.SaveOrders(db, "KUCOIN", "BINANCE", base, buyAmount, minPrice, maxPrice)
-			if err != nil {
-				log.Fatalln("Save error:", err)
-			}
+	if dust(buyMarket, instId, long) && dust(sellMarket, instId, short) {
+		// Nothing stayed open
+		if transferred {
+			returnCollateral(sellEx)
 		}
+		return
+	}
+
+	clk.Sleep(1 * time.Second)
+	*isOpen = true
+
+	// Keep what the exchanges executed rather than what was signalled
+	*orders = utils.OrderData{
+		BuyMarket:   buyMarket,
+		SellMarket:  sellMarket,
+		BuyPrice:    fillPrice(bought, minPrice),
+		SellPrice:   fillPrice(sold, maxPrice),
+		Amount:      long,
+		SellAmount:  short,
+		BuyOrderId:  bought.orderId,
+		SellOrderId: sold.orderId,
+		Coin:        base,
+	}
+	log.Printf("opened %s: bought %f at %f on %s (fee %f %s), shorted %f at %f on %s (fee %f %s)",
+		instId, long, orders.BuyPrice, buyMarket, bought.fee, bought.feeAsset,
+		short, orders.SellPrice, sellMarket, sold.fee, sold.feeAsset)
+	if err := utils.SaveOrders(db, *orders); err != nil {
+		log.Println("Save error:", err)
+	}
+}
+
+// fillPrice is the average price of a leg, or the signalled price when the
+// exchanges did not report one.
+func fillPrice(fill legFill, signalled float64) float64 {
+	if fill.price > 0 {
+		return fill.price
 	}
+	return signalled
 }
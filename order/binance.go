@@ -1,78 +1,89 @@
 package order
 
 import (
+	"arbitrage/rest"
 	"context"
 	"fmt"
-	"log"
 	"strings"
 
 	"github.com/adshao/go-binance/v2"
 )
 
-func Binance(marketType string, quantity float64, instId string) error {
-	client := binance.NewClient(binanceAPIKey, binanceAPISecret)
-
-	symbol := strings.Replace(instId, "-", "", -1)
-	side := binance.SideTypeBuy
-	orderType := binance.OrderTypeMarket
-
-	var err error
-	if marketType == "SPOT" {
-		_, err = client.NewCreateOrderService().Symbol(symbol).Side(side).Type(orderType).Quantity(fmt.Sprintf("%f", quantity)).Do(context.Background())
-	} else if marketType == "MARGIN" {
-		_, err = client.NewCreateMarginOrderService().Symbol(symbol).Side(binance.SideTypeSell).Type(orderType).Quantity(fmt.Sprintf("%f", quantity)).Do(context.Background())
-	} else {
-		return nil
-	}
-	if err != nil {
-		return err
+func Binance(marketType string, quantity float64, instId string, reference float64) (OrderResult, error) {
+	side := Buy
+	if marketType == "MARGIN" {
+		side = Sell
 	}
+	return PlaceBinance(OrderRequest{InstId: instId, Side: side, Type: Market, MarketType: marketType, Quantity: quantity, Reference: reference})
+}
 
-	return nil
+func BinanceReverse(marketType string, quantity float64, instId string, reference float64) (OrderResult, error) {
+	side := Sell
+	if marketType == "MARGIN" {
+		side = Buy
+	}
+	return PlaceBinance(OrderRequest{InstId: instId, Side: side, Type: Market, MarketType: marketType, Quantity: quantity, Reference: reference})
 }
 
-func BinanceReverse(marketType string, quantity float64, instId string) error {
-	client := binance.NewClient(binanceAPIKey, binanceAPISecret)
+// BinanceLimit places a limit order on either side of the spot or margin
+// market. An empty timeInForce means GTC.
+func BinanceLimit(marketType, side, timeInForce string, price float64, quantity float64, instId string) (OrderResult, error) {
+	return PlaceBinance(OrderRequest{InstId: instId, Side: side, Type: Limit, MarketType: marketType, TimeInForce: timeInForce, Price: price, Quantity: quantity})
+}
 
-	symbol := strings.Replace(instId, "-", "", -1)
-	side := binance.SideTypeSell
-	orderType := binance.OrderTypeMarket
+// PlaceBinance places req and returns the executions Binance reported with it.
+func PlaceBinance(req OrderRequest) (OrderResult, error) {
+	client := rest.BinanceClient()
 
-	var err error
-	if marketType == "SPOT" {
-		_, err = client.NewCreateOrderService().Symbol(symbol).Side(side).Type(orderType).Quantity(fmt.Sprintf("%f", quantity)).Do(context.Background())
-	} else if marketType == "MARGIN" {
-		_, err = client.NewCreateMarginOrderService().Symbol(symbol).Side(binance.SideTypeBuy).Type(orderType).Quantity(fmt.Sprintf("%f", quantity)).Do(context.Background())
-	} else {
-		return nil
+	var price float64
+	if req.Type == Limit {
+		price = req.Price
 	}
+	px, qty, err := formatOrder("BINANCE", req.InstId, req.Side, price, req.Reference, req.Quantity)
 	if err != nil {
-		return err
+		return OrderResult{}, err
 	}
 
-	return nil
+	return submit("BINANCE", req, func(req OrderRequest) (OrderResult, error) {
+		return sendBinance(client, req, px, qty)
+	})
 }
 
-func BinanceLimit(marketType string, price float64, quantity float64, instId string) {
-	client := binance.NewClient(binanceAPIKey, binanceAPISecret)
-
-	symbol := strings.Replace(instId, "-", "", -1)
-	side := binance.SideTypeBuy
-	orderType := binance.OrderTypeLimit
+func sendBinance(client *binance.Client, req OrderRequest, px, qty string) (OrderResult, error) {
+	symbol := binanceSymbol(req.InstId)
+	side := binance.SideType(strings.ToUpper(req.Side))
+	orderType := binance.OrderType(strings.ToUpper(req.Type))
+	postOnly := req.Type == Limit && req.timeInForce() == PostOnly
+	if postOnly {
+		orderType = binance.OrderTypeLimitMaker // Takes no time in force
+	}
 
-	var order *binance.CreateOrderResponse
+	var res *binance.CreateOrderResponse
 	var err error
-	if marketType == "SPOT" {
-		order, err = client.NewCreateOrderService().Symbol(symbol).Side(side).Type(orderType).Price(fmt.Sprintf("%f", price)).Quantity(fmt.Sprintf("%f", quantity)).TimeInForce(binance.TimeInForceTypeGTC).Do(context.Background())
-	} else if marketType == "MARGIN" {
-		order, err = client.NewCreateMarginOrderService().Symbol(symbol).Side(binance.SideTypeBuy).Price(fmt.Sprintf("%f", price)).Type(orderType).Quantity(fmt.Sprintf("%f", quantity)).TimeInForce(binance.TimeInForceTypeGTC).Do(context.Background())
-	} else {
-		return
+	switch req.MarketType {
+	case "SPOT":
+		service := client.NewCreateOrderService().Symbol(symbol).Side(side).Type(orderType).Quantity(qty).
+			NewClientOrderID(req.ClientId).NewOrderRespType(binance.NewOrderRespTypeFULL)
+		if postOnly {
+			service = service.Price(px)
+		} else if req.Type == Limit {
+			service = service.Price(px).TimeInForce(binance.TimeInForceType(req.timeInForce()))
+		}
+		res, err = service.Do(context.Background(), rest.RecvWindow())
+	case "MARGIN":
+		service := client.NewCreateMarginOrderService().Symbol(symbol).Side(side).Type(orderType).Quantity(qty).
+			NewClientOrderID(req.ClientId).NewOrderRespType(binance.NewOrderRespTypeFULL)
+		if postOnly {
+			service = service.Price(px)
+		} else if req.Type == Limit {
+			service = service.Price(px).TimeInForce(binance.TimeInForceType(req.timeInForce()))
+		}
+		res, err = service.Do(context.Background(), rest.RecvWindow())
+	default:
+		return OrderResult{}, fmt.Errorf("binance: unsupported market type %s", req.MarketType)
 	}
 	if err != nil {
-		log.Println("Binance order error:", err)
-		return
+		return OrderResult{}, err
 	}
-
-	fmt.Println("Order placed successfully:", order)
+	return binanceResult(req, res), nil
 }
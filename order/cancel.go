@@ -0,0 +1,66 @@
+package order
+
+import (
+	"arbitrage/rest"
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+)
+
+// Cancel cancels an open order and returns its final state, including
+// whatever executed before the cancel. Cancelling an order that already
+// finished returns its final state as well.
+func Cancel(result OrderResult) (OrderResult, error) {
+	var err error
+	switch result.Exchange {
+	case "BINANCE":
+		err = binanceCancel(result)
+	case "KUCOIN":
+		_, err = kucoinRequest("DELETE", "/api/v1/orders/"+result.OrderId, nil)
+	default:
+		return result, fmt.Errorf("unknown exchange %s", result.Exchange)
+	}
+
+	// The cancel fails when the order finished first; its status tells
+	latest, statusErr := orderStatus(result)
+	if statusErr != nil {
+		if err != nil {
+			return result, err
+		}
+		return result, statusErr
+	}
+	if !Final(latest.Status) {
+		if err == nil {
+			err = fmt.Errorf("%s order %s still %s after cancel", result.Exchange, result.OrderId, latest.Status)
+		}
+		return latest, err
+	}
+	journalRemove(latest.ClientId)
+
+	if latest.ExecutedQty > 0 {
+		confirmed, err := orderTrades(latest)
+		if err != nil {
+			log.Printf("%s order %s trades: %v", latest.Exchange, latest.OrderId, err)
+		} else {
+			latest = confirmed
+		}
+	}
+	return latest, nil
+}
+
+func binanceCancel(result OrderResult) error {
+	client := rest.BinanceClient()
+	orderId, err := strconv.ParseInt(result.OrderId, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	symbol := binanceSymbol(result.InstId)
+	if result.MarketType == "MARGIN" {
+		_, err = client.NewCancelMarginOrderService().Symbol(symbol).OrderID(orderId).Do(context.Background(), rest.RecvWindow())
+	} else {
+		_, err = client.NewCancelOrderService().Symbol(symbol).OrderID(orderId).Do(context.Background(), rest.RecvWindow())
+	}
+	return err
+}
@@ -0,0 +1,240 @@
+package order
+
+import (
+	"arbitrage/events"
+	"arbitrage/rest"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+)
+
+// ConfirmTimeout bounds how long Confirm waits for an order to finish.
+var ConfirmTimeout = 10 * time.Second
+
+const confirmInterval = 250 * time.Millisecond
+
+// FillError reports an order that did not fully execute. Result holds what
+// did execute, which may be nothing for a rejected or expired order.
+type FillError struct {
+	Result  OrderResult
+	Timeout bool // The order was still open when ConfirmTimeout passed
+}
+
+func (e *FillError) Error() string {
+	if e.Timeout {
+		return fmt.Sprintf("%s order %s on %s unconfirmed after %s: %s, %f of %f executed",
+			e.Result.Exchange, e.Result.OrderId, e.Result.InstId, ConfirmTimeout, e.Result.Status, e.Result.ExecutedQty, e.Result.Quantity)
+	}
+	return fmt.Sprintf("%s order %s on %s %s: %f of %f executed",
+		e.Result.Exchange, e.Result.OrderId, e.Result.InstId, e.Result.Status, e.Result.ExecutedQty, e.Result.Quantity)
+}
+
+// Final reports whether an order in status can no longer execute.
+func Final(status string) bool {
+	switch status {
+	case StatusFilled, StatusCanceled, StatusRejected, StatusExpired:
+		return true
+	}
+	return false
+}
+
+// Confirm polls the exchange until a placed order is final and totals its
+// trades into the executed quantity, average price and fee. Anything short
+// of a full fill is returned along with a *FillError.
+func Confirm(result OrderResult) (OrderResult, error) {
+	deadline := time.Now().Add(ConfirmTimeout)
+	for {
+		latest, err := orderStatus(result)
+		if err != nil {
+			log.Printf("%s order %s status: %v", result.Exchange, result.OrderId, err)
+		} else {
+			result = latest
+		}
+		if Final(result.Status) {
+			journalRemove(result.ClientId)
+			break
+		}
+		if time.Now().After(deadline) {
+			return result, &FillError{Result: result, Timeout: true}
+		}
+		// A user data stream announces the end of the order before the next poll would
+		events.WaitFor(confirmInterval, finished(result))
+	}
+
+	if result.ExecutedQty > 0 {
+		confirmed, err := orderTrades(result)
+		if err != nil {
+			// The status alone already carries the executed quantity
+			log.Printf("%s order %s trades: %v", result.Exchange, result.OrderId, err)
+		} else {
+			result = confirmed
+		}
+	}
+	if result.Status != StatusFilled {
+		return result, &FillError{Result: result}
+	}
+	return result, nil
+}
+
+// finished matches the stream event that makes the order of result final.
+func finished(result OrderResult) func(events.Event) bool {
+	return func(e events.Event) bool {
+		return e.Order != nil && e.Exchange == result.Exchange && e.Order.OrderId == result.OrderId && Final(e.Order.Status)
+	}
+}
+
+func orderStatus(result OrderResult) (OrderResult, error) {
+	switch result.Exchange {
+	case "BINANCE":
+		return binanceOrderStatus(result)
+	case "KUCOIN":
+		latest, err := KucoinOrder(result.OrderId)
+		if err != nil {
+			return result, err
+		}
+		latest.MarketType = result.MarketType
+		return latest, nil
+	}
+	return result, fmt.Errorf("unknown exchange %s", result.Exchange)
+}
+
+func orderTrades(result OrderResult) (OrderResult, error) {
+	switch result.Exchange {
+	case "BINANCE":
+		return binanceOrderTrades(result)
+	case "KUCOIN":
+		return kucoinOrderTrades(result)
+	}
+	return result, fmt.Errorf("unknown exchange %s", result.Exchange)
+}
+
+func binanceOrderStatus(result OrderResult) (OrderResult, error) {
+	client := rest.BinanceClient()
+	orderId, err := strconv.ParseInt(result.OrderId, 10, 64)
+	if err != nil {
+		return result, err
+	}
+
+	var o *binance.Order
+	symbol := binanceSymbol(result.InstId)
+	if result.MarketType == "MARGIN" {
+		o, err = client.NewGetMarginOrderService().Symbol(symbol).OrderID(orderId).Do(context.Background(), rest.RecvWindow())
+	} else {
+		o, err = client.NewGetOrderService().Symbol(symbol).OrderID(orderId).Do(context.Background(), rest.RecvWindow())
+	}
+	if err != nil {
+		return result, err
+	}
+
+	result.Status = string(o.Status)
+	result.Quantity = parseFloat(o.OrigQuantity)
+	result.ExecutedQty = parseFloat(o.ExecutedQuantity)
+	if result.ExecutedQty > 0 {
+		result.AvgPrice = parseFloat(o.CummulativeQuoteQuantity) / result.ExecutedQty
+	}
+	result.time = o.Time
+	return result, nil
+}
+
+func binanceOrderTrades(result OrderResult) (OrderResult, error) {
+	client := rest.BinanceClient()
+	orderId, err := strconv.ParseInt(result.OrderId, 10, 64)
+	if err != nil {
+		return result, err
+	}
+
+	var trades []*binance.TradeV3
+	symbol := binanceSymbol(result.InstId)
+	if result.MarketType == "MARGIN" {
+		// The margin trade list cannot filter by order, so start at the order time
+		service := client.NewListMarginTradesService().Symbol(symbol).Limit(1000)
+		if result.time > 0 {
+			service = service.StartTime(result.time)
+		}
+		trades, err = service.Do(context.Background(), rest.RecvWindow())
+	} else {
+		trades, err = client.NewListTradesService().Symbol(symbol).OrderId(orderId).Do(context.Background(), rest.RecvWindow())
+	}
+	if err != nil {
+		return result, err
+	}
+
+	var fills []tradeFill
+	for _, t := range trades {
+		if t.OrderID == orderId {
+			fills = append(fills, tradeFill{parseFloat(t.Quantity), parseFloat(t.QuoteQuantity), parseFloat(t.Commission), t.CommissionAsset})
+		}
+	}
+	return applyFills(result, fills), nil
+}
+
+type kucoinFill struct {
+	Size        string `json:"size"`
+	Funds       string `json:"funds"`
+	Fee         string `json:"fee"`
+	FeeCurrency string `json:"feeCurrency"`
+}
+
+func kucoinOrderTrades(result OrderResult) (OrderResult, error) {
+	params := url.Values{}
+	params.Set("orderId", result.OrderId)
+	params.Set("tradeType", "TRADE")
+	if result.MarketType == "MARGIN" {
+		params.Set("tradeType", "MARGIN_TRADE")
+	}
+	data, err := kucoinRequest("GET", "/api/v1/fills?"+params.Encode(), nil)
+	if err != nil {
+		return result, err
+	}
+	var page struct {
+		Items []kucoinFill `json:"items"`
+	}
+	if err := json.Unmarshal(data, &page); err != nil {
+		return result, err
+	}
+
+	var fills []tradeFill
+	for _, f := range page.Items {
+		fills = append(fills, tradeFill{parseFloat(f.Size), parseFloat(f.Funds), parseFloat(f.Fee), f.FeeCurrency})
+	}
+	return applyFills(result, fills), nil
+}
+
+type tradeFill struct {
+	qty      float64
+	quoteQty float64
+	fee      float64
+	feeAsset string
+}
+
+// applyFills replaces the executed quantity, average price and fee of result
+// with the totals of its trades. Trades can lag behind the order status, so
+// fewer trades than the status reports leave the status figures in place.
+func applyFills(result OrderResult, fills []tradeFill) OrderResult {
+	var qty, quote, fee float64
+	feeAsset := ""
+	for _, f := range fills {
+		qty += f.qty
+		quote += f.quoteQty
+		if feeAsset == "" {
+			feeAsset = f.feeAsset
+		}
+		if f.feeAsset == feeAsset {
+			fee += f.fee
+		}
+	}
+	if qty == 0 || qty < result.ExecutedQty*(1-1e-9) {
+		return result
+	}
+	result.ExecutedQty = qty
+	result.AvgPrice = quote / qty
+	result.Fee = fee
+	result.FeeAsset = feeAsset
+	return result
+}
@@ -0,0 +1,296 @@
+package order
+
+import (
+	"arbitrage/rest"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/adshao/go-binance/v2/common"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// The journal keeps every order from just before it is sent until its final
+// state is known, keyed by client order ID. After a timeout or a crash the
+// journal says which orders may have reached an exchange, and looking them up
+// by client ID tells whether they did.
+
+// PendingOrder is a journalled order whose final state is not known yet.
+type PendingOrder struct {
+	Exchange string       `json:"exchange"`
+	Request  OrderRequest `json:"request"`
+	Created  time.Time    `json:"created"`
+}
+
+const (
+	journalPrefix = "pending/"
+	sendAttempts  = 3
+	placedTTL     = 24 * time.Hour
+)
+
+var (
+	journalMu sync.Mutex
+	journal   *leveldb.DB
+
+	// placed remembers the market type of every order sent in the last
+	// placedTTL by client ID, for streams whose order events do not say
+	placedMu sync.Mutex
+	placed   = map[string]placedOrder{}
+)
+
+type placedOrder struct {
+	marketType string
+	at         time.Time
+}
+
+// errOrderNotFound is returned by lookups of a client ID the exchange does not know.
+var errOrderNotFound = errors.New("order not found")
+
+// UseJournal persists client order IDs in db. Without it orders are sent
+// unjournalled.
+func UseJournal(db *leveldb.DB) {
+	journalMu.Lock()
+	defer journalMu.Unlock()
+	journal = db
+}
+
+func journalAdd(p PendingOrder) error {
+	journalMu.Lock()
+	defer journalMu.Unlock()
+	if journal == nil {
+		return nil
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return journal.Put([]byte(journalPrefix+p.Request.ClientId), data, &opt.WriteOptions{Sync: true})
+}
+
+func remember(req OrderRequest) {
+	placedMu.Lock()
+	defer placedMu.Unlock()
+	now := time.Now()
+	for clientId, p := range placed {
+		if now.Sub(p.at) > placedTTL {
+			delete(placed, clientId)
+		}
+	}
+	placed[req.ClientId] = placedOrder{marketType: req.MarketType, at: now}
+}
+
+// MarketTypeOf returns the market type of the order sent with clientId in
+// the last day, or "" for an order this process did not send.
+func MarketTypeOf(clientId string) string {
+	placedMu.Lock()
+	defer placedMu.Unlock()
+	return placed[clientId].marketType
+}
+
+func journalRemove(clientId string) {
+	journalMu.Lock()
+	defer journalMu.Unlock()
+	if journal == nil || clientId == "" {
+		return
+	}
+	if err := journal.Delete([]byte(journalPrefix+clientId), nil); err != nil {
+		log.Println("order journal:", err)
+	}
+}
+
+// Pending lists the journalled orders.
+func Pending() ([]PendingOrder, error) {
+	journalMu.Lock()
+	defer journalMu.Unlock()
+	if journal == nil {
+		return nil, nil
+	}
+	var pending []PendingOrder
+	iter := journal.NewIterator(util.BytesPrefix([]byte(journalPrefix)), nil)
+	defer iter.Release()
+	for iter.Next() {
+		var p PendingOrder
+		if err := json.Unmarshal(iter.Value(), &p); err != nil {
+			log.Printf("order journal: %s: %v", iter.Key(), err)
+			continue
+		}
+		pending = append(pending, p)
+	}
+	return pending, iter.Error()
+}
+
+// submit journals req under its client ID and sends it. When the outcome of
+// a send is unknown, the client ID is looked up to see whether the order
+// arrived, and the order is sent again with the same client ID if it did not.
+func submit(exchange string, req OrderRequest, send func(req OrderRequest) (OrderResult, error)) (OrderResult, error) {
+	req.ClientId = req.clientId()
+	if err := journalAdd(PendingOrder{Exchange: exchange, Request: req, Created: time.Now()}); err != nil {
+		return OrderResult{}, fmt.Errorf("order journal: %v", err)
+	}
+	remember(req)
+
+	for attempt := 1; ; attempt++ {
+		result, err := send(req)
+		if err == nil {
+			if Final(result.Status) {
+				journalRemove(req.ClientId)
+			}
+			return result, nil
+		}
+		if !uncertain(err) {
+			journalRemove(req.ClientId)
+			return OrderResult{}, err
+		}
+
+		log.Printf("%s order %s: %v, looking it up", exchange, req.ClientId, err)
+		found, lookupErr := lookup(exchange, req)
+		if lookupErr == nil {
+			if Final(found.Status) {
+				journalRemove(req.ClientId)
+			}
+			return found, nil
+		}
+		if !errors.Is(lookupErr, errOrderNotFound) || attempt == sendAttempts {
+			// Left in the journal; Reconcile settles it on the next start
+			return OrderResult{}, fmt.Errorf("%v (lookup: %v)", err, lookupErr)
+		}
+		time.Sleep(time.Duration(attempt) * 500 * time.Millisecond)
+	}
+}
+
+// uncertain reports whether err leaves open whether the order reached the
+// exchange: the request timed out or failed in transit, or the exchange
+// answered with a server error.
+func uncertain(err error) bool {
+	if errors.Is(err, rest.ErrRateLimited) {
+		return false // Held back before sending
+	}
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var apiErr *common.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Code == 0 || apiErr.Code == -1006 || apiErr.Code == -1007 // Unknown send status
+	}
+	var exErr *rest.Error
+	if errors.As(err, &exErr) {
+		return exErr.Status >= 500
+	}
+	return false
+}
+
+// lookup fetches an order by the client ID it was sent with.
+func lookup(exchange string, req OrderRequest) (OrderResult, error) {
+	switch exchange {
+	case "BINANCE":
+		return binanceClientOrder(req)
+	case "KUCOIN":
+		return kucoinClientOrder(req)
+	}
+	return OrderResult{}, fmt.Errorf("unknown exchange %s", exchange)
+}
+
+func binanceClientOrder(req OrderRequest) (OrderResult, error) {
+	client := rest.BinanceClient()
+	symbol := binanceSymbol(req.InstId)
+
+	var o *binance.Order
+	var err error
+	if req.MarketType == "MARGIN" {
+		o, err = client.NewGetMarginOrderService().Symbol(symbol).OrigClientOrderID(req.ClientId).Do(context.Background(), rest.RecvWindow())
+	} else {
+		o, err = client.NewGetOrderService().Symbol(symbol).OrigClientOrderID(req.ClientId).Do(context.Background(), rest.RecvWindow())
+	}
+	var apiErr *common.APIError
+	if errors.As(err, &apiErr) && apiErr.Code == -2013 {
+		return OrderResult{}, errOrderNotFound
+	}
+	if err != nil {
+		return OrderResult{}, err
+	}
+	return binanceOrderResult(req, o), nil
+}
+
+func binanceOrderResult(req OrderRequest, o *binance.Order) OrderResult {
+	result := OrderResult{
+		Exchange:    "BINANCE",
+		InstId:      req.InstId,
+		Side:        strings.ToLower(string(o.Side)),
+		Type:        strings.ToLower(string(o.Type)),
+		MarketType:  req.MarketType,
+		TimeInForce: string(o.TimeInForce),
+		ClientId:    o.ClientOrderID,
+		OrderId:     fmt.Sprint(o.OrderID),
+		Status:      string(o.Status),
+		Quantity:    parseFloat(o.OrigQuantity),
+		ExecutedQty: parseFloat(o.ExecutedQuantity),
+		time:        o.Time,
+	}
+	if result.ExecutedQty > 0 {
+		result.AvgPrice = parseFloat(o.CummulativeQuoteQuantity) / result.ExecutedQty
+	}
+	return result
+}
+
+func kucoinClientOrder(req OrderRequest) (OrderResult, error) {
+	data, err := kucoinRequest("GET", "/api/v1/order/client-order/"+req.ClientId, nil)
+	var exErr *rest.Error
+	if errors.As(err, &exErr) && (exErr.Status == 404 || strings.Contains(strings.ToLower(exErr.Msg), "not exist")) {
+		return OrderResult{}, errOrderNotFound
+	}
+	if err != nil {
+		return OrderResult{}, err
+	}
+	if len(data) == 0 || string(data) == "null" {
+		return OrderResult{}, errOrderNotFound
+	}
+	var detail kucoinOrderDetail
+	if err := json.Unmarshal(data, &detail); err != nil {
+		return OrderResult{}, err
+	}
+	result := kucoinResult(detail)
+	result.MarketType = req.MarketType
+	return result, nil
+}
+
+// Reconcile looks up every journalled order on its exchange and drops the
+// ones that are final or never arrived. It returns the state of each, so
+// that the caller can account for executions it did not see.
+func Reconcile() ([]OrderResult, error) {
+	pending, err := Pending()
+	if err != nil {
+		return nil, err
+	}
+	var results []OrderResult
+	for _, p := range pending {
+		result, err := lookup(p.Exchange, p.Request)
+		if errors.Is(err, errOrderNotFound) {
+			log.Printf("%s order %s never reached the exchange", p.Exchange, p.Request.ClientId)
+			journalRemove(p.Request.ClientId)
+			continue
+		}
+		if err != nil {
+			return results, fmt.Errorf("%s order %s: %v", p.Exchange, p.Request.ClientId, err)
+		}
+		if Final(result.Status) {
+			if result.ExecutedQty > 0 {
+				if confirmed, err := orderTrades(result); err == nil {
+					result = confirmed
+				}
+			}
+			journalRemove(p.Request.ClientId)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
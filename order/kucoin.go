@@ -1,19 +1,12 @@
 package order
 
 import (
+	"arbitrage/rest"
 	"bytes"
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
-	"strconv"
-	"time"
-
-	"github.com/google/uuid"
 )
 
 // KuCoin API configuration
@@ -21,204 +14,209 @@ var (
 	baseURL = "https://api.kucoin.com"
 )
 
-func Kucoin(orderType string, symbol string, amount float64) error {
-	if orderType == "SPOT" {
-		side := "buy"                                    // Only buying is allowed for spot orders
-		size := strconv.FormatFloat(amount, 'f', -1, 64) // Amount to buy
+type kucoinOrderDetail struct {
+	Id          string `json:"id"`
+	Symbol      string `json:"symbol"`
+	Type        string `json:"type"`
+	Side        string `json:"side"`
+	Price       string `json:"price"`
+	Size        string `json:"size"`
+	DealFunds   string `json:"dealFunds"`
+	DealSize    string `json:"dealSize"`
+	Fee         string `json:"fee"`
+	FeeCurrency string `json:"feeCurrency"`
+	TimeInForce string `json:"timeInForce"`
+	ClientOid   string `json:"clientOid"`
+	TradeType   string `json:"tradeType"`
+	IsActive    bool   `json:"isActive"`
+	CancelExist bool   `json:"cancelExist"`
+}
 
-		// Create the spot order
-		_, err := createSpotOrder(symbol, side, size)
-		if err != nil {
-			return err
-		} else {
-			return nil
-		}
-	} else if orderType == "MARGIN" {
-		side := "sell"                                   // Selling only
-		size := strconv.FormatFloat(amount, 'f', -1, 64) // Amount to sell
-		autoBorrow := true                               // Enable auto-borrowing for leverage
-
-		_, err := createMarginOrder(symbol, side, size, autoBorrow)
-		if err != nil {
-			return err
-		} else {
-			return nil
-		}
+func Kucoin(orderType string, symbol string, amount, reference float64) (OrderResult, error) {
+	side := Buy // Only buying is allowed for spot orders
+	if orderType == "MARGIN" {
+		side = Sell // Selling only, borrowing the base asset
+	}
+	return PlaceKucoin(OrderRequest{InstId: symbol, Side: side, Type: Market, MarketType: orderType, Quantity: amount, Reference: reference})
+}
+
+func KucoinReverse(orderType string, symbol string, amount, reference float64) (OrderResult, error) {
+	side := Sell // Close the spot position
+	if orderType == "MARGIN" {
+		side = Buy // Buy back the short
 	}
-	return nil
+	return PlaceKucoin(OrderRequest{InstId: symbol, Side: side, Type: Market, MarketType: orderType, Quantity: amount, Reference: reference})
 }
 
-func KucoinReverse(orderType string, symbol string, amount float64) error {
-	if orderType == "SPOT" {
-		side := "sell"                                   // Only buying is allowed for spot orders
-		size := strconv.FormatFloat(amount, 'f', -1, 64) // Amount to buy
+// KucoinLimit places a limit order on either side of the spot or margin
+// market. An empty timeInForce means GTC.
+func KucoinLimit(orderType, symbol, side, timeInForce string, price, amount float64) (OrderResult, error) {
+	return PlaceKucoin(OrderRequest{InstId: symbol, Side: side, Type: Limit, MarketType: orderType, TimeInForce: timeInForce, Price: price, Quantity: amount})
+}
 
-		// Create the spot order
-		_, err := createSpotOrder(symbol, side, size)
-		if err != nil {
-			return err
-		} else {
-			return nil
-		}
-	} else if orderType == "MARGIN" {
-		side := "buy"                                    // Selling only
-		size := strconv.FormatFloat(amount, 'f', -1, 64) // Amount to sell
-		autoBorrow := true                               // Enable auto-borrowing for leverage
-
-		// Create the margin order
-		_, err := createMarginOrder(symbol, side, size, autoBorrow)
-		if err != nil {
-			return err
-		} else {
-			return nil
-		}
+// PlaceKucoin places req and returns the order as KuCoin reports it right
+// after placement.
+func PlaceKucoin(req OrderRequest) (OrderResult, error) {
+	var price float64
+	if req.Type == Limit {
+		price = req.Price
 	}
-	return nil
+	px, size, err := formatOrder("KUCOIN", req.InstId, req.Side, price, req.Reference, req.Quantity)
+	if err != nil {
+		return OrderResult{}, err
+	}
+
+	return submit("KUCOIN", req, func(req OrderRequest) (OrderResult, error) {
+		return sendKucoin(req, px, size)
+	})
 }
 
-func createSpotOrder(symbol, side, size string) (string, error) {
-	// Prepare the request payload for spot order
+func sendKucoin(req OrderRequest, px, size string) (OrderResult, error) {
+	// Prepare the request payload
 	order := map[string]interface{}{
-		"clientOid": uuid.New().String(),
-		"symbol":    symbol,
-		"side":      side,
-		"type":      "market", // Market order type
+		"clientOid": req.ClientId,
+		"symbol":    req.InstId,
+		"side":      req.Side,
+		"type":      req.Type,
 		"size":      size,
 	}
-
-	payload, err := json.Marshal(order)
-	if err != nil {
-		return "", err
+	if req.Type == Limit {
+		order["price"] = px
+		if req.timeInForce() == PostOnly {
+			order["timeInForce"] = GTC
+			order["postOnly"] = true
+		} else {
+			order["timeInForce"] = req.timeInForce()
+		}
 	}
 
-	// Create the request
 	endpoint := "/api/v1/orders"
-	url := baseURL + endpoint
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(payload))
-	if err != nil {
-		return "", err
+	switch req.MarketType {
+	case "SPOT":
+	case "MARGIN":
+		endpoint = "/api/v1/margin/order"
+		order["marginModel"] = "cross"
+		order["autoBorrow"] = true // Enable auto-borrowing for leverage
+		order["tradeType"] = "MARGIN_TRADE"
+	default:
+		return OrderResult{}, fmt.Errorf("kucoin: unsupported market type %s", req.MarketType)
 	}
 
-	// Add headers
-	timestamp := strconv.FormatInt(time.Now().UnixMilli(), 10)
-	signature := signRequest(kucoinAPISecret, timestamp, "POST", endpoint, string(payload))
-	req.Header.Set("KC-API-KEY", kucoinAPIKey)
-	req.Header.Set("KC-API-SIGN", signature)
-	req.Header.Set("KC-API-TIMESTAMP", timestamp)
-	req.Header.Set("KC-API-PASSPHRASE", signPassphrase(kucoinPassphrase))
-	req.Header.Set("KC-API-KEY-VERSION", "2")
-	req.Header.Set("Content-Type", "application/json")
-
-	// Execute the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	payload, err := json.Marshal(order)
 	if err != nil {
-		return "", err
+		return OrderResult{}, err
 	}
-	defer resp.Body.Close()
-
-	// Read and parse the response
-	body, err := io.ReadAll(resp.Body)
+	data, err := kucoinRequest("POST", endpoint, payload)
 	if err != nil {
-		return "", err
+		return OrderResult{}, err
 	}
-
-	log.Println(body)
-
-	var result map[string]interface{}
-	if err := json.Unmarshal(body, &result); err != nil {
-		return "", err
+	var placed struct {
+		OrderId string `json:"orderId"`
 	}
-
-	// Check for errors in the response
-	if resp.StatusCode != http.StatusOK || result["code"] != "200000" {
-		return "", fmt.Errorf("error response: %s", string(body))
+	if err := json.Unmarshal(data, &placed); err != nil {
+		return OrderResult{}, err
 	}
 
-	// Return the order ID
-	data := result["data"].(map[string]interface{})
-	return data["orderId"].(string), nil
+	result, err := KucoinOrder(placed.OrderId)
+	if err != nil {
+		// The order is placed; report it even though its state is unknown
+		return OrderResult{
+			Exchange:    "KUCOIN",
+			InstId:      req.InstId,
+			Side:        req.Side,
+			Type:        req.Type,
+			MarketType:  req.MarketType,
+			TimeInForce: req.TimeInForce,
+			ClientId:    req.ClientId,
+			OrderId:     placed.OrderId,
+			Status:      StatusNew,
+			Quantity:    parseFloat(size),
+		}, nil
+	}
+	result.MarketType = req.MarketType
+	return result, nil
 }
 
-func createMarginOrder(symbol, side, size string, autoBorrow bool) (string, error) {
-	// Prepare the request payload for margin order
-	order := map[string]interface{}{
-		"clientOid":   uuid.New().String(),
-		"symbol":      symbol,
-		"side":        side,
-		"type":        "market",
-		"size":        size,
-		"marginModel": "cross",
-		"autoBorrow":  autoBorrow,
-		"tradeType":   "MARGIN_TRADE",
-	}
-
-	payload, err := json.Marshal(order)
+// KucoinOrder fetches the current state of an order.
+func KucoinOrder(orderId string) (OrderResult, error) {
+	data, err := kucoinRequest("GET", "/api/v1/orders/"+orderId, nil)
 	if err != nil {
-		return "", err
+		return OrderResult{}, err
 	}
+	var detail kucoinOrderDetail
+	if err := json.Unmarshal(data, &detail); err != nil {
+		return OrderResult{}, err
+	}
+	return kucoinResult(detail), nil
+}
+
+func kucoinResult(detail kucoinOrderDetail) OrderResult {
+	result := OrderResult{
+		Exchange:    "KUCOIN",
+		InstId:      detail.Symbol,
+		Side:        detail.Side,
+		Type:        detail.Type,
+		MarketType:  "SPOT",
+		TimeInForce: detail.TimeInForce,
+		ClientId:    detail.ClientOid,
+		OrderId:     detail.Id,
+		Quantity:    parseFloat(detail.Size),
+		ExecutedQty: parseFloat(detail.DealSize),
+		Fee:         parseFloat(detail.Fee),
+		FeeAsset:    detail.FeeCurrency,
+	}
+	if detail.TradeType == "MARGIN_TRADE" {
+		result.MarketType = "MARGIN"
+	}
+	if result.ExecutedQty > 0 {
+		result.AvgPrice = parseFloat(detail.DealFunds) / result.ExecutedQty
+	}
+
+	// KuCoin only reports whether the order is still open and whether a cancel happened
+	switch {
+	case detail.IsActive && result.ExecutedQty > 0:
+		result.Status = StatusPartiallyFilled
+	case detail.IsActive:
+		result.Status = StatusNew
+	case result.ExecutedQty > 0 && result.ExecutedQty >= result.Quantity:
+		result.Status = StatusFilled
+	case detail.CancelExist:
+		result.Status = StatusCanceled
+	case result.ExecutedQty > 0:
+		result.Status = StatusFilled // Market orders by funds have no size
+	default:
+		result.Status = StatusCanceled
+	}
+	return result
+}
 
-	// Create the request
-	endpoint := "/api/v1/margin/order"
-	url := baseURL + endpoint
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(payload))
+// kucoinRequest sends a signed request and returns the data of a successful response.
+func kucoinRequest(method, endpoint string, payload []byte) (json.RawMessage, error) {
+	req, err := http.NewRequest(method, baseURL+endpoint, bytes.NewBuffer(payload))
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	// Add headers
-	timestamp := strconv.FormatInt(time.Now().UnixMilli(), 10)
-	signature := signRequest(kucoinAPISecret, timestamp, "POST", endpoint, string(payload))
-	req.Header.Set("KC-API-KEY", kucoinAPIKey)
-	req.Header.Set("KC-API-SIGN", signature)
-	req.Header.Set("KC-API-TIMESTAMP", timestamp)
-	req.Header.Set("KC-API-PASSPHRASE", signPassphrase(kucoinPassphrase))
-	req.Header.Set("KC-API-KEY-VERSION", "2")
+	rest.SignKucoin(req, endpoint, string(payload))
 	req.Header.Set("Content-Type", "application/json")
 
 	// Execute the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := rest.Kucoin.Do(req)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	// Read and parse the response
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", err
-	}
-
-	log.Println(body)
-
-	var result map[string]interface{}
-	if err := json.Unmarshal(body, &result); err != nil {
-		return "", err
+		return nil, err
 	}
 
-	// Check for errors in the response
-	if resp.StatusCode != http.StatusOK || result["code"] != "200000" {
-		return "", fmt.Errorf("error response: %s", string(body))
+	var data json.RawMessage
+	if err := rest.DecodeKucoin(resp, body, &data); err != nil {
+		return nil, err
 	}
-
-	// Return the order ID
-	data := result["data"].(map[string]interface{})
-	return data["orderId"].(string), nil
-}
-
-func signRequest(secret, timestamp, method, endpoint, body string) string {
-	// Prepare the prehash string
-	prehash := timestamp + method + endpoint + body
-
-	// Create the HMAC signature
-	h := hmac.New(sha256.New, []byte(secret))
-	h.Write([]byte(prehash))
-	return base64.StdEncoding.EncodeToString(h.Sum(nil))
-}
-
-func signPassphrase(passphrase string) string {
-	h := hmac.New(sha256.New, []byte(kucoinAPISecret))
-	h.Write([]byte(passphrase))
-	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+	return data, nil
 }
@@ -1,6 +1,7 @@
 package order
 
 import (
+	"arbitrage/instrument"
 	"log"
 	"os"
 
@@ -54,3 +55,21 @@ func init() {
 		log.Fatal("Missing required API keys or secrets in environment variables")
 	}
 }
+
+// formatOrder rounds quantity, and price for limit orders, to the rules of
+// instId on exchange and validates the result. Market orders pass a zero price
+// and the reference price their notional is checked at.
+func formatOrder(exchange, instId, side string, price, reference, quantity float64) (string, string, error) {
+	inst, err := instrument.Get(exchange, instId)
+	if err != nil {
+		return "", "", err
+	}
+	qty := inst.RoundQty(quantity)
+	if price > 0 {
+		price = inst.RoundPrice(price, side)
+	}
+	if err := inst.Validate(price, qty, reference); err != nil {
+		return "", "", err
+	}
+	return inst.FormatPrice(price), inst.FormatQty(qty), nil
+}
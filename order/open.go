@@ -0,0 +1,156 @@
+package order
+
+import (
+	"arbitrage/instrument"
+	"arbitrage/rest"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/adshao/go-binance/v2"
+)
+
+// marketTypes are the markets orders are placed on.
+var marketTypes = []string{"SPOT", "MARGIN"}
+
+// OpenOrders lists the orders resting on the marketType market of exchange.
+// An empty instId lists every symbol.
+func OpenOrders(exchange, marketType, instId string) ([]OrderResult, error) {
+	switch exchange {
+	case "BINANCE":
+		return binanceOpenOrders(marketType, instId)
+	case "KUCOIN":
+		return kucoinOpenOrders(marketType, instId)
+	}
+	return nil, fmt.Errorf("unknown exchange %s", exchange)
+}
+
+// CancelClientOrder cancels the order sent with clientId and returns its
+// final state.
+func CancelClientOrder(exchange, marketType, instId, clientId string) (OrderResult, error) {
+	req := OrderRequest{InstId: instId, MarketType: marketType, ClientId: clientId}
+	result, err := lookup(exchange, req)
+	if err != nil {
+		return OrderResult{}, err
+	}
+	if Final(result.Status) {
+		journalRemove(clientId)
+		return result, nil
+	}
+	return Cancel(result)
+}
+
+// CancelAll cancels every open order on both markets of exchange. With
+// onlyOwn set, orders placed by hand are left alone. It returns the final
+// state of every cancelled order and carries on past failures, reporting
+// the first.
+func CancelAll(exchange string, onlyOwn bool) ([]OrderResult, error) {
+	var cancelled []OrderResult
+	var firstErr error
+	for _, marketType := range marketTypes {
+		open, err := OpenOrders(exchange, marketType, "")
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%s %s open orders: %v", exchange, marketType, err)
+			}
+			continue
+		}
+		for _, o := range open {
+			if onlyOwn && !strings.HasPrefix(o.ClientId, ClientIdPrefix) {
+				continue
+			}
+			result, err := Cancel(o)
+			if err != nil {
+				log.Printf("%s cancel %s %s: %v", exchange, o.InstId, o.OrderId, err)
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			cancelled = append(cancelled, result)
+		}
+	}
+	return cancelled, firstErr
+}
+
+func binanceOpenOrders(marketType, instId string) ([]OrderResult, error) {
+	client := rest.BinanceClient()
+
+	var orders []*binance.Order
+	var err error
+	switch marketType {
+	case "SPOT":
+		service := client.NewListOpenOrdersService()
+		if instId != "" {
+			service = service.Symbol(binanceSymbol(instId))
+		}
+		orders, err = service.Do(context.Background(), rest.RecvWindow())
+	case "MARGIN":
+		service := client.NewListMarginOpenOrdersService()
+		if instId != "" {
+			service = service.Symbol(binanceSymbol(instId))
+		}
+		orders, err = service.Do(context.Background(), rest.RecvWindow())
+	default:
+		return nil, fmt.Errorf("binance: unsupported market type %s", marketType)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]OrderResult, 0, len(orders))
+	for _, o := range orders {
+		id := instId
+		if id == "" {
+			id = o.Symbol
+			if inst, ok := instrument.BySymbol("BINANCE", o.Symbol); ok {
+				id = inst.InstId
+			}
+		}
+		results = append(results, binanceOrderResult(OrderRequest{InstId: id, MarketType: marketType}, o))
+	}
+	return results, nil
+}
+
+func kucoinOpenOrders(marketType, instId string) ([]OrderResult, error) {
+	query := url.Values{"status": {"active"}, "pageSize": {"500"}}
+	switch marketType {
+	case "SPOT":
+		query.Set("tradeType", "TRADE")
+	case "MARGIN":
+		query.Set("tradeType", "MARGIN_TRADE")
+	default:
+		return nil, fmt.Errorf("kucoin: unsupported market type %s", marketType)
+	}
+	if instId != "" {
+		query.Set("symbol", instId)
+	}
+
+	var results []OrderResult
+	for page := 1; ; page++ {
+		query.Set("currentPage", strconv.Itoa(page))
+		data, err := kucoinRequest("GET", "/api/v1/orders?"+query.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		var list struct {
+			TotalPage int                 `json:"totalPage"`
+			Items     []kucoinOrderDetail `json:"items"`
+		}
+		if err := json.Unmarshal(data, &list); err != nil {
+			return nil, err
+		}
+		for _, detail := range list.Items {
+			result := kucoinResult(detail)
+			result.MarketType = marketType
+			results = append(results, result)
+		}
+		if page >= list.TotalPage {
+			return results, nil
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package order
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/google/uuid"
+)
+
+// Order sides, types and times in force. Exchange specific spellings are
+// mapped by each connector.
+const (
+	Buy  = "buy"
+	Sell = "sell"
+
+	Market = "market"
+	Limit  = "limit"
+
+	GTC = "GTC" // Good till cancelled
+	IOC = "IOC" // Immediate or cancel
+	FOK = "FOK" // Fill or kill
+	// PostOnly rests on the book as a maker order and is rejected if it
+	// would execute immediately.
+	PostOnly = "POST_ONLY"
+)
+
+// Order statuses, shared by both exchanges.
+const (
+	StatusNew             = "NEW"
+	StatusPartiallyFilled = "PARTIALLY_FILLED"
+	StatusFilled          = "FILLED"
+	StatusCanceled        = "CANCELED"
+	StatusRejected        = "REJECTED"
+	StatusExpired         = "EXPIRED"
+)
+
+// OrderRequest describes an order to place.
+type OrderRequest struct {
+	InstId      string // e.g. BTC-USDT
+	Side        string // Buy or Sell
+	Type        string // Market or Limit
+	MarketType  string // SPOT or MARGIN
+	TimeInForce string // Limit orders only: GTC when empty, IOC, FOK or PostOnly
+	Price       float64
+	Quantity    float64
+	ClientId    string // Generated when empty
+	// Reference is, for market orders, the best price on the side of the
+	// book the order takes, which the minimum notional is checked at.
+	Reference float64
+}
+
+// OrderResult is what the exchange reported about a placed order.
+type OrderResult struct {
+	Exchange    string
+	InstId      string
+	Side        string
+	Type        string
+	MarketType  string
+	TimeInForce string
+	ClientId    string
+	OrderId     string
+	Status      string
+	Quantity    float64 // Requested, after rounding
+	ExecutedQty float64
+	AvgPrice    float64
+	Fee         float64
+	FeeAsset    string
+
+	time int64 // Binance order creation time in milliseconds, for trade lookups
+}
+
+// Filled reports whether the whole order executed.
+func (r OrderResult) Filled() bool {
+	return r.Status == StatusFilled
+}
+
+// ClientIdPrefix starts every client order ID generated here, telling the
+// orders of this program apart from orders placed by hand. With the 32 hex
+// digits of a UUID it stays within the 36 characters Binance allows.
+const ClientIdPrefix = "arb-"
+
+func (r OrderRequest) clientId() string {
+	if r.ClientId != "" {
+		return r.ClientId
+	}
+	return ClientIdPrefix + strings.ReplaceAll(uuid.New().String(), "-", "")
+}
+
+func (r OrderRequest) timeInForce() string {
+	if r.TimeInForce != "" {
+		return r.TimeInForce
+	}
+	return GTC
+}
+
+// binanceResult converts a FULL order response. Fees paid in several assets
+// are summed only for the first asset seen, which in practice is the only one.
+func binanceResult(req OrderRequest, res *binance.CreateOrderResponse) OrderResult {
+	result := OrderResult{
+		Exchange:    "BINANCE",
+		InstId:      req.InstId,
+		Side:        req.Side,
+		Type:        req.Type,
+		MarketType:  req.MarketType,
+		TimeInForce: string(res.TimeInForce),
+		ClientId:    res.ClientOrderID,
+		OrderId:     strconv.FormatInt(res.OrderID, 10),
+		Status:      string(res.Status),
+		Quantity:    parseFloat(res.OrigQuantity),
+		ExecutedQty: parseFloat(res.ExecutedQuantity),
+		time:        res.TransactTime,
+	}
+	if quote := parseFloat(res.CummulativeQuoteQuantity); result.ExecutedQty > 0 {
+		result.AvgPrice = quote / result.ExecutedQty
+	}
+	for _, fill := range res.Fills {
+		if result.FeeAsset == "" {
+			result.FeeAsset = fill.CommissionAsset
+		}
+		if fill.CommissionAsset == result.FeeAsset {
+			result.Fee += parseFloat(fill.Commission)
+		}
+	}
+	return result
+}
+
+func parseFloat(s string) float64 {
+	v, _ := strconv.ParseFloat(s, 64)
+	return v
+}
+
+func binanceSymbol(instId string) string {
+	return strings.Replace(instId, "-", "", -1)
+}
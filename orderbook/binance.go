@@ -0,0 +1,152 @@
+package orderbook
+
+import (
+	"arbitrage/rest"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type binanceDepthEvent struct {
+	Event         string     `json:"e"`
+	Time          int64      `json:"E"`
+	Symbol        string     `json:"s"`
+	FirstUpdateID int64      `json:"U"`
+	FinalUpdateID int64      `json:"u"`
+	Bids          [][]string `json:"b"`
+	Asks          [][]string `json:"a"`
+}
+
+type binanceSnapshot struct {
+	LastUpdateID int64      `json:"lastUpdateId"`
+	Bids         [][]string `json:"bids"`
+	Asks         [][]string `json:"asks"`
+}
+
+// SyncBinance maintains the books of instIds from a REST snapshot plus the
+// @depth@100ms diff stream. It returns when ctx is cancelled or the
+// connection fails, leaving every book invalidated.
+func SyncBinance(ctx context.Context, instIds []string, books *Books) error {
+	stitchers := make(map[string]*stitcher) // BTCUSDT -> stitcher
+	streams := make([]string, 0, len(instIds))
+	for _, instId := range instIds {
+		symbol := strings.Replace(instId, "-", "", -1)
+		stitchers[symbol] = &stitcher{
+			name: "binance " + instId,
+			book: books.getOrCreate(instId),
+			fetch: func() ([]Level, []Level, int64, error) {
+				return binanceDepthSnapshot(symbol)
+			},
+		}
+		streams = append(streams, strings.ToLower(symbol)+"@depth@100ms")
+	}
+	defer books.InvalidateAll()
+
+	endpoint := "wss://stream.binance.com:9443/ws/" + strings.Join(streams, "/")
+	c, _, err := websocket.DefaultDialer.Dial(endpoint, nil)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Close()
+		case <-done:
+		}
+	}()
+
+	for {
+		c.SetReadDeadline(time.Now().Add(readTimeout))
+		_, message, err := c.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		var event binanceDepthEvent
+		if err := json.Unmarshal(message, &event); err != nil {
+			log.Println("decode error binance depth", err)
+			continue
+		}
+		s, ok := stitchers[event.Symbol]
+		if !ok {
+			continue
+		}
+
+		d := diff{first: event.FirstUpdateID, last: event.FinalUpdateID, time: event.Time}
+		if d.bids, err = parseChanges(event.Bids, event.FinalUpdateID); err != nil {
+			log.Println("decode error binance depth", err)
+			continue
+		}
+		if d.asks, err = parseChanges(event.Asks, event.FinalUpdateID); err != nil {
+			log.Println("decode error binance depth", err)
+			continue
+		}
+		s.handle(d)
+	}
+}
+
+func binanceDepthSnapshot(symbol string) ([]Level, []Level, int64, error) {
+	params := url.Values{}
+	params.Set("symbol", symbol)
+	params.Set("limit", "100")
+
+	req, err := http.NewRequest("GET", "https://api.binance.com/api/v3/depth?"+params.Encode(), nil)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	resp, err := rest.Binance.Do(req)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	if err := rest.BinanceError(resp, body); err != nil {
+		return nil, nil, 0, fmt.Errorf("binance depth: %w", err)
+	}
+
+	var snapshot binanceSnapshot
+	if err := json.Unmarshal(body, &snapshot); err != nil {
+		return nil, nil, 0, err
+	}
+	bids, err := parseLevels(snapshot.Bids)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	asks, err := parseLevels(snapshot.Asks)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	return bids, asks, snapshot.LastUpdateID, nil
+}
+
+// parseChanges converts [price, qty] pairs that all belong to one update.
+func parseChanges(raw [][]string, sequence int64) ([]change, error) {
+	levels, err := parseLevels(raw)
+	if err != nil {
+		return nil, err
+	}
+	changes := make([]change, len(levels))
+	for i, l := range levels {
+		changes[i] = change{price: l.Price, qty: l.Qty, sequence: sequence}
+	}
+	return changes, nil
+}
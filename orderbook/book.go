@@ -0,0 +1,303 @@
+package orderbook
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+)
+
+type Side int
+
+const (
+	Bid Side = iota
+	Ask
+)
+
+type Level struct {
+	Price float64
+	Qty   float64
+}
+
+// Book is a level-2 order book for one symbol. Bids are kept in descending
+// and asks in ascending price order so the best level is always first.
+type Book struct {
+	mu       sync.RWMutex
+	bids     []Level
+	asks     []Level
+	sequence int64
+	synced   bool
+	time     int64
+}
+
+func New() *Book {
+	return &Book{}
+}
+
+// Reset replaces the whole book with a snapshot taken at sequence.
+func (b *Book) Reset(bids, asks []Level, sequence int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.bids = b.bids[:0]
+	b.asks = b.asks[:0]
+	for _, l := range bids {
+		b.set(Bid, l.Price, l.Qty)
+	}
+	for _, l := range asks {
+		b.set(Ask, l.Price, l.Qty)
+	}
+	b.sequence = sequence
+	b.synced = true
+}
+
+// Invalidate marks the book as out of sync until the next Reset.
+func (b *Book) Invalidate() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.synced = false
+}
+
+// Synced reports whether the book reflects an unbroken sequence of updates.
+func (b *Book) Synced() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.synced
+}
+
+// Sequence is the exchange sequence of the last applied update.
+func (b *Book) Sequence() int64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.sequence
+}
+
+// Time is the exchange timestamp in milliseconds of the last applied update.
+func (b *Book) Time() int64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.time
+}
+
+// diff is a batch of level changes covering the sequence range [first, last].
+type diff struct {
+	first, last int64
+	time        int64
+	bids, asks  []change
+}
+
+type change struct {
+	price, qty float64
+	sequence   int64
+}
+
+// applyDiff applies the changes newer than the book and reports false when
+// the diff leaves a gap after the current sequence.
+func (b *Book) applyDiff(d diff) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if d.last <= b.sequence {
+		return true
+	}
+	if d.first > b.sequence+1 {
+		return false
+	}
+	for _, c := range d.bids {
+		if c.sequence > b.sequence {
+			b.set(Bid, c.price, c.qty)
+		}
+	}
+	for _, c := range d.asks {
+		if c.sequence > b.sequence {
+			b.set(Ask, c.price, c.qty)
+		}
+	}
+	b.sequence = d.last
+	if d.time > 0 {
+		b.time = d.time
+	}
+	return true
+}
+
+func (b *Book) set(side Side, price, qty float64) {
+	levels := &b.asks
+	better := func(i int) bool { return (*levels)[i].Price >= price }
+	if side == Bid {
+		levels = &b.bids
+		better = func(i int) bool { return (*levels)[i].Price <= price }
+	}
+
+	i := sort.Search(len(*levels), better)
+	found := i < len(*levels) && (*levels)[i].Price == price
+	switch {
+	case qty == 0 && found:
+		*levels = append((*levels)[:i], (*levels)[i+1:]...)
+	case qty == 0:
+	case found:
+		(*levels)[i].Qty = qty
+	default:
+		*levels = append(*levels, Level{})
+		copy((*levels)[i+1:], (*levels)[i:])
+		(*levels)[i] = Level{Price: price, Qty: qty}
+	}
+}
+
+// Best returns the top level of a side.
+func (b *Book) Best(side Side) (Level, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	levels := b.levels(side)
+	if len(levels) == 0 {
+		return Level{}, false
+	}
+	return levels[0], true
+}
+
+// Depth returns a copy of the best n levels of a side.
+func (b *Book) Depth(side Side, n int) []Level {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	levels := b.levels(side)
+	if n > len(levels) {
+		n = len(levels)
+	}
+	return append([]Level(nil), levels[:n]...)
+}
+
+// Fill walks a side of the book spending up to notional (in quote currency)
+// and returns the volume weighted average price and the quantity obtained.
+// Buying consumes the Ask side and selling the Bid side.
+func (b *Book) Fill(side Side, notional float64) (vwap, qty float64) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	spent := 0.0
+	for _, l := range b.levels(side) {
+		if spent >= notional {
+			break
+		}
+		take := l.Qty
+		if remaining := notional - spent; take*l.Price > remaining {
+			take = remaining / l.Price
+		}
+		spent += take * l.Price
+		qty += take
+	}
+	if qty == 0 {
+		return 0, 0
+	}
+	return spent / qty, qty
+}
+
+// FillQuantity is like Fill but walks the book until quantity is obtained.
+func (b *Book) FillQuantity(side Side, quantity float64) (vwap, qty float64) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	spent := 0.0
+	for _, l := range b.levels(side) {
+		if qty >= quantity {
+			break
+		}
+		take := l.Qty
+		if remaining := quantity - qty; take > remaining {
+			take = remaining
+		}
+		spent += take * l.Price
+		qty += take
+	}
+	if qty == 0 {
+		return 0, 0
+	}
+	return spent / qty, qty
+}
+
+// WorstPrice returns the price of the last level FillQuantity walks to obtain
+// quantity, the limit at which an order takes all of it at once.
+func (b *Book) WorstPrice(side Side, quantity float64) float64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	var qty, price float64
+	for _, l := range b.levels(side) {
+		if qty >= quantity {
+			break
+		}
+		qty += l.Qty
+		price = l.Price
+	}
+	return price
+}
+
+// Available returns the quantity a side offers at limit or better, which is
+// what a limit order at limit can take right away.
+func (b *Book) Available(side Side, limit float64) float64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	qty := 0.0
+	for _, l := range b.levels(side) {
+		if (side == Ask && l.Price > limit) || (side == Bid && l.Price < limit) {
+			break
+		}
+		qty += l.Qty
+	}
+	return qty
+}
+
+func (b *Book) levels(side Side) []Level {
+	if side == Bid {
+		return b.bids
+	}
+	return b.asks
+}
+
+// Books holds the books of one exchange keyed by instId (e.g. BTC-USDT).
+type Books struct {
+	mu    sync.RWMutex
+	books map[string]*Book
+}
+
+func NewBooks() *Books {
+	return &Books{books: make(map[string]*Book)}
+}
+
+// Get returns the book for instId, or nil when the symbol is not maintained.
+func (bs *Books) Get(instId string) *Book {
+	bs.mu.RLock()
+	defer bs.mu.RUnlock()
+	return bs.books[instId]
+}
+
+func (bs *Books) getOrCreate(instId string) *Book {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	b, ok := bs.books[instId]
+	if !ok {
+		b = New()
+		bs.books[instId] = b
+	}
+	return b
+}
+
+// InvalidateAll marks every book as out of sync.
+func (bs *Books) InvalidateAll() {
+	bs.mu.RLock()
+	defer bs.mu.RUnlock()
+	for _, b := range bs.books {
+		b.Invalidate()
+	}
+}
+
+func parseLevels(raw [][]string) ([]Level, error) {
+	levels := make([]Level, 0, len(raw))
+	for _, r := range raw {
+		if len(r) < 2 {
+			continue
+		}
+		price, err := strconv.ParseFloat(r[0], 64)
+		if err != nil {
+			return nil, err
+		}
+		qty, err := strconv.ParseFloat(r[1], 64)
+		if err != nil {
+			return nil, err
+		}
+		levels = append(levels, Level{Price: price, Qty: qty})
+	}
+	return levels, nil
+}
@@ -0,0 +1,118 @@
+package orderbook
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestApplyDiff(t *testing.T) {
+	bids := []Level{{Price: 99, Qty: 1}, {Price: 98, Qty: 2}}
+	asks := []Level{{Price: 101, Qty: 1}, {Price: 102, Qty: 2}}
+
+	tests := []struct {
+		name       string
+		diff       diff
+		ok         bool
+		sequence   int64
+		bids, asks []Level
+	}{
+		{
+			name:     "stale diff is skipped",
+			diff:     diff{first: 90, last: 100, bids: []change{{price: 99, qty: 5, sequence: 100}}},
+			ok:       true,
+			sequence: 100,
+			bids:     bids,
+			asks:     asks,
+		},
+		{
+			name:     "gap after the book",
+			diff:     diff{first: 102, last: 105, bids: []change{{price: 99, qty: 5, sequence: 105}}},
+			ok:       false,
+			sequence: 100,
+			bids:     bids,
+			asks:     asks,
+		},
+		{
+			name: "next diff updates, adds and removes levels",
+			diff: diff{first: 101, last: 103, time: 7, bids: []change{
+				{price: 99, qty: 3, sequence: 103},
+				{price: 100, qty: 1, sequence: 103},
+			}, asks: []change{
+				{price: 101, qty: 0, sequence: 103},
+				{price: 103, qty: 0, sequence: 103}, // Removing a missing level is a no-op
+			}},
+			ok:       true,
+			sequence: 103,
+			bids:     []Level{{Price: 100, Qty: 1}, {Price: 99, Qty: 3}, {Price: 98, Qty: 2}},
+			asks:     []Level{{Price: 102, Qty: 2}},
+		},
+		{
+			// Binance: the first event after a snapshot at lastUpdateId has U <= lastUpdateId+1 <= u
+			name:     "binance event straddling the snapshot",
+			diff:     diff{first: 95, last: 110, asks: []change{{price: 100.5, qty: 4, sequence: 110}}},
+			ok:       true,
+			sequence: 110,
+			bids:     bids,
+			asks:     []Level{{Price: 100.5, Qty: 4}, {Price: 101, Qty: 1}, {Price: 102, Qty: 2}},
+		},
+		{
+			// KuCoin: every change carries its own sequence and those the snapshot has are skipped
+			name: "kucoin changes older than the book are skipped",
+			diff: diff{first: 99, last: 102, bids: []change{
+				{price: 99, qty: 7, sequence: 99},
+				{price: 98, qty: 0, sequence: 100},
+				{price: 97, qty: 1, sequence: 101},
+			}, asks: []change{
+				{price: 101, qty: 9, sequence: 102},
+			}},
+			ok:       true,
+			sequence: 102,
+			bids:     []Level{{Price: 99, Qty: 1}, {Price: 98, Qty: 2}, {Price: 97, Qty: 1}},
+			asks:     []Level{{Price: 101, Qty: 9}, {Price: 102, Qty: 2}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := New()
+			b.Reset(bids, asks, 100)
+			if ok := b.applyDiff(tt.diff); ok != tt.ok {
+				t.Fatalf("applyDiff = %v, want %v", ok, tt.ok)
+			}
+			if got := b.Sequence(); got != tt.sequence {
+				t.Errorf("sequence = %d, want %d", got, tt.sequence)
+			}
+			if got := b.Depth(Bid, 10); !reflect.DeepEqual(got, tt.bids) {
+				t.Errorf("bids = %v, want %v", got, tt.bids)
+			}
+			if got := b.Depth(Ask, 10); !reflect.DeepEqual(got, tt.asks) {
+				t.Errorf("asks = %v, want %v", got, tt.asks)
+			}
+		})
+	}
+}
+
+func TestWorstPrice(t *testing.T) {
+	b := New()
+	b.Reset([]Level{{Price: 99, Qty: 1}, {Price: 98, Qty: 2}}, []Level{{Price: 101, Qty: 1}, {Price: 102, Qty: 2}, {Price: 103, Qty: 5}}, 1)
+
+	tests := []struct {
+		side     Side
+		quantity float64
+		want     float64
+	}{
+		{Ask, 0.5, 101},
+		{Ask, 1, 101},
+		{Ask, 1.5, 102},
+		{Ask, 3, 102},
+		{Ask, 4, 103},
+		{Ask, 100, 103}, // The whole side
+		{Bid, 2, 98},
+		{Bid, 0, 0},
+	}
+	for _, tt := range tests {
+		if got := b.WorstPrice(tt.side, tt.quantity); got != tt.want {
+			t.Errorf("WorstPrice(%v, %v) = %v, want %v", tt.side, tt.quantity, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package orderbook
+
+import (
+	"arbitrage/rest"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Kucoin/kucoin-go-sdk"
+)
+
+type kucoinLevel2 struct {
+	Changes struct {
+		Asks [][]string `json:"asks"`
+		Bids [][]string `json:"bids"`
+	} `json:"changes"`
+	SequenceStart int64  `json:"sequenceStart"`
+	SequenceEnd   int64  `json:"sequenceEnd"`
+	Symbol        string `json:"symbol"`
+	Time          int64  `json:"time"`
+}
+
+type kucoinSnapshot struct {
+	Sequence string     `json:"sequence"`
+	Time     int64      `json:"time"`
+	Bids     [][]string `json:"bids"`
+	Asks     [][]string `json:"asks"`
+}
+
+// KuCoin accepts at most 100 symbols per level2 topic.
+const kucoinTopicSize = 100
+
+// SyncKucoin maintains the books of instIds from a REST snapshot plus the
+// /market/level2 change stream, stitching changes by sequence. It returns
+// when ctx is cancelled or the connection fails, leaving every book invalidated.
+func SyncKucoin(ctx context.Context, instIds []string, books *Books) error {
+	stitchers := make(map[string]*stitcher)
+	for _, instId := range instIds {
+		symbol := instId
+		stitchers[symbol] = &stitcher{
+			name: "kucoin " + symbol,
+			book: books.getOrCreate(symbol),
+			fetch: func() ([]Level, []Level, int64, error) {
+				return kucoinDepthSnapshot(symbol)
+			},
+		}
+	}
+	defer books.InvalidateAll()
+
+	s := kucoin.NewApiService()
+	rsp, err := s.WebSocketPublicToken()
+	if err != nil {
+		return err
+	}
+	tk := &kucoin.WebSocketTokenModel{}
+	if err := rsp.ReadData(tk); err != nil {
+		return err
+	}
+
+	c := s.NewWebSocketClient(tk)
+	mc, ec, err := c.Connect()
+	if err != nil {
+		return err
+	}
+	defer c.Stop()
+
+	for start := 0; start < len(instIds); start += kucoinTopicSize {
+		end := start + kucoinTopicSize
+		if end > len(instIds) {
+			end = len(instIds)
+		}
+		topic := kucoin.NewSubscribeMessage("/market/level2:"+strings.Join(instIds[start:end], ","), false)
+		if err := c.Subscribe(topic); err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-ec:
+			return err
+		case msg, ok := <-mc:
+			if !ok {
+				return fmt.Errorf("kucoin level2 stream closed")
+			}
+			var update kucoinLevel2
+			if err := msg.ReadData(&update); err != nil {
+				log.Println("decode error kucoin level2:", err)
+				continue
+			}
+			st, ok := stitchers[update.Symbol]
+			if !ok {
+				continue
+			}
+
+			d := diff{first: update.SequenceStart, last: update.SequenceEnd, time: update.Time}
+			if d.bids, err = parseKucoinChanges(update.Changes.Bids); err != nil {
+				log.Println("decode error kucoin level2:", err)
+				continue
+			}
+			if d.asks, err = parseKucoinChanges(update.Changes.Asks); err != nil {
+				log.Println("decode error kucoin level2:", err)
+				continue
+			}
+			st.handle(d)
+		}
+	}
+}
+
+func kucoinDepthSnapshot(symbol string) ([]Level, []Level, int64, error) {
+	req, err := http.NewRequest("GET", "https://api.kucoin.com/api/v1/market/orderbook/level2_100?symbol="+symbol, nil)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	resp, err := rest.Kucoin.Do(req)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	var snapshot kucoinSnapshot
+	if err := rest.DecodeKucoin(resp, body, &snapshot); err != nil {
+		return nil, nil, 0, fmt.Errorf("kucoin depth %s: %w", symbol, err)
+	}
+
+	sequence, err := strconv.ParseInt(snapshot.Sequence, 10, 64)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	bids, err := parseLevels(snapshot.Bids)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	asks, err := parseLevels(snapshot.Asks)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	return bids, asks, sequence, nil
+}
+
+// parseKucoinChanges converts [price, size, sequence] triples. A zero price
+// only advances the sequence and a zero size removes the level.
+func parseKucoinChanges(raw [][]string) ([]change, error) {
+	changes := make([]change, 0, len(raw))
+	for _, r := range raw {
+		if len(r) < 3 {
+			continue
+		}
+		price, err := strconv.ParseFloat(r[0], 64)
+		if err != nil {
+			return nil, err
+		}
+		qty, err := strconv.ParseFloat(r[1], 64)
+		if err != nil {
+			return nil, err
+		}
+		sequence, err := strconv.ParseInt(r[2], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		if price == 0 {
+			continue
+		}
+		changes = append(changes, change{price: price, qty: qty, sequence: sequence})
+	}
+	return changes, nil
+}
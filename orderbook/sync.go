@@ -0,0 +1,69 @@
+package orderbook
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// readTimeout bounds how long a diff stream may stay silent before it is
+// considered dead.
+const readTimeout = 1 * time.Minute
+
+// stitcher keeps one book consistent with an exchange diff stream. Diffs are
+// buffered while a REST snapshot is loading and replayed on top of it, and any
+// sequence gap invalidates the book and triggers a fresh snapshot.
+type stitcher struct {
+	mu      sync.Mutex
+	name    string
+	book    *Book
+	fetch   func() (bids, asks []Level, sequence int64, err error)
+	loading bool
+	buffer  []diff
+}
+
+func (s *stitcher) handle(d diff) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.loading {
+		s.buffer = append(s.buffer, d)
+		return
+	}
+	if s.book.Synced() && s.book.applyDiff(d) {
+		return
+	}
+
+	if s.book.Synced() {
+		log.Printf("%s: sequence gap at %d, resyncing", s.name, d.first)
+	}
+	s.book.Invalidate()
+	s.loading = true
+	s.buffer = append(s.buffer[:0], d)
+	go s.resync()
+}
+
+func (s *stitcher) resync() {
+	bids, asks, sequence, err := s.fetch()
+	if err != nil {
+		log.Printf("%s: snapshot failed: %v", s.name, err)
+		time.Sleep(1 * time.Second) // The next diff retries
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loading = false
+	if err != nil {
+		s.buffer = nil
+		return
+	}
+
+	s.book.Reset(bids, asks, sequence)
+	for _, d := range s.buffer {
+		if !s.book.applyDiff(d) {
+			// The snapshot is older than the buffered diffs; the next diff fetches another
+			s.book.Invalidate()
+			break
+		}
+	}
+	s.buffer = nil
+}
@@ -0,0 +1,148 @@
+package orderbook
+
+import (
+	"testing"
+	"time"
+)
+
+type snapshot struct {
+	bids, asks []Level
+	sequence   int64
+}
+
+// testStitcher returns a stitcher whose snapshots are handed over on the
+// returned channel once it asks for one.
+func testStitcher() (*stitcher, chan snapshot) {
+	snapshots := make(chan snapshot)
+	s := &stitcher{name: "test", book: New(), fetch: func() ([]Level, []Level, int64, error) {
+		snap := <-snapshots
+		return snap.bids, snap.asks, snap.sequence, nil
+	}}
+	return s, snapshots
+}
+
+// settle waits for the snapshot being loaded to be applied.
+func settle(t *testing.T, s *stitcher) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		s.mu.Lock()
+		loading := s.loading
+		s.mu.Unlock()
+		if !loading {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("snapshot never applied")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func bid(price, qty float64, sequence int64) change {
+	return change{price: price, qty: qty, sequence: sequence}
+}
+
+func TestStitcher(t *testing.T) {
+	tests := []struct {
+		name     string
+		diffs    []diff // The first starts the snapshot, the rest arrive while it loads
+		snapshot snapshot
+		synced   bool
+		sequence int64
+		best     Level
+	}{
+		{
+			name: "binance diffs replayed on the snapshot",
+			diffs: []diff{
+				{first: 95, last: 100, bids: []change{bid(99, 9, 100)}},
+				{first: 101, last: 104, bids: []change{bid(99, 2, 104)}},
+				{first: 105, last: 106, bids: []change{bid(100, 1, 106)}},
+			},
+			snapshot: snapshot{bids: []Level{{Price: 99, Qty: 1}}, sequence: 102},
+			synced:   true,
+			sequence: 106,
+			best:     Level{Price: 100, Qty: 1},
+		},
+		{
+			name: "kucoin changes replayed by their own sequence",
+			diffs: []diff{
+				{first: 10, last: 12, bids: []change{bid(99, 9, 10), bid(98, 1, 12)}},
+				{first: 13, last: 13, bids: []change{bid(99, 3, 13)}},
+			},
+			snapshot: snapshot{bids: []Level{{Price: 99, Qty: 1}}, sequence: 11},
+			synced:   true,
+			sequence: 13,
+			best:     Level{Price: 99, Qty: 3},
+		},
+		{
+			name: "snapshot older than the buffered diffs",
+			diffs: []diff{
+				{first: 101, last: 101, bids: []change{bid(99, 2, 101)}},
+			},
+			snapshot: snapshot{bids: []Level{{Price: 99, Qty: 1}}, sequence: 90},
+			synced:   false,
+			sequence: 90,
+			best:     Level{Price: 99, Qty: 1},
+		},
+		{
+			name: "gap between buffered diffs",
+			diffs: []diff{
+				{first: 100, last: 101, bids: []change{bid(99, 2, 101)}},
+				{first: 103, last: 104, bids: []change{bid(99, 4, 104)}},
+			},
+			snapshot: snapshot{bids: []Level{{Price: 99, Qty: 1}}, sequence: 100},
+			synced:   false,
+			sequence: 101,
+			best:     Level{Price: 99, Qty: 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, snapshots := testStitcher()
+			for _, d := range tt.diffs {
+				s.handle(d)
+			}
+			snapshots <- tt.snapshot
+			settle(t, s)
+
+			if got := s.book.Synced(); got != tt.synced {
+				t.Errorf("synced = %v, want %v", got, tt.synced)
+			}
+			if got := s.book.Sequence(); got != tt.sequence {
+				t.Errorf("sequence = %d, want %d", got, tt.sequence)
+			}
+			if got, _ := s.book.Best(Bid); got != tt.best {
+				t.Errorf("best bid = %v, want %v", got, tt.best)
+			}
+		})
+	}
+}
+
+func TestStitcherResyncsOnGap(t *testing.T) {
+	s, snapshots := testStitcher()
+	s.handle(diff{first: 1, last: 1})
+	snapshots <- snapshot{bids: []Level{{Price: 99, Qty: 1}}, sequence: 10}
+	settle(t, s)
+
+	s.handle(diff{first: 11, last: 12, bids: []change{bid(99, 2, 12)}})
+	if !s.book.Synced() || s.book.Sequence() != 12 {
+		t.Fatalf("contiguous diff: synced %v at %d, want synced at 12", s.book.Synced(), s.book.Sequence())
+	}
+
+	s.handle(diff{first: 14, last: 15, bids: []change{bid(99, 5, 15)}})
+	if s.book.Synced() {
+		t.Fatal("book still synced after a gap")
+	}
+	s.handle(diff{first: 16, last: 16, bids: []change{bid(98, 1, 16)}})
+	snapshots <- snapshot{bids: []Level{{Price: 99, Qty: 4}}, sequence: 14}
+	settle(t, s)
+
+	if !s.book.Synced() || s.book.Sequence() != 16 {
+		t.Fatalf("after resync: synced %v at %d, want synced at 16", s.book.Synced(), s.book.Sequence())
+	}
+	if got := s.book.Depth(Bid, 10); len(got) != 2 || got[0] != (Level{Price: 99, Qty: 5}) || got[1] != (Level{Price: 98, Qty: 1}) {
+		t.Errorf("bids = %v, want [{99 5} {98 1}]", got)
+	}
+}
@@ -0,0 +1,228 @@
+// Package recorder writes every normalized quote the bot sees to disk.
+//
+// File format: a recording directory holds files named
+// ticks-YYYYMMDD-HHMMSS.jsonl.gz (UTC creation time), so sorting the names
+// sorts the files by time. Each file is a gzip stream of newline-delimited
+// JSON, one Record per line in receive order:
+//
+//	{"ex":"BINANCE","sym":"BTC-USDT","bp":"64000.1","bs":"0.5","ap":"64000.2","as":"1.2","ts":1718000000000,"rt":1718000000012345}
+//
+// ex and sym identify the quote, bp/bs and ap/as are the best bid and ask
+// prices and sizes exactly as sent by the exchange, ts is the exchange
+// timestamp in milliseconds and rt the local receive timestamp in
+// microseconds. A record with "stale":true and no sym marks a disconnect of
+// ex: none of its earlier quotes are valid until fresh ones arrive.
+//
+// Files are append-only. A new file is started every hour or once 256 MiB
+// of uncompressed records were written, and a finished file is never
+// reopened. The gzip stream is flushed every second, so a crash loses
+// at most the last second of data.
+package recorder
+
+import (
+	"arbitrage/exchange"
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Record struct {
+	Exchange string `json:"ex"`
+	Symbol   string `json:"sym,omitempty"`
+	BidPrice string `json:"bp,omitempty"`
+	BidSize  string `json:"bs,omitempty"`
+	AskPrice string `json:"ap,omitempty"`
+	AskSize  string `json:"as,omitempty"`
+	Time     int64  `json:"ts,omitempty"`
+	Received int64  `json:"rt"`
+	Stale    bool   `json:"stale,omitempty"`
+}
+
+// FromTicker converts a quote into its on-disk form.
+func FromTicker(t exchange.TickerGeneral) Record {
+	return Record{
+		Exchange: t.Market,
+		Symbol:   t.InstId,
+		BidPrice: t.BidPrice,
+		BidSize:  t.BidSize,
+		AskPrice: t.AskPrice,
+		AskSize:  t.AskSize,
+		Time:     t.Time,
+		Received: t.Received,
+		Stale:    t.Stale,
+	}
+}
+
+// Ticker converts a record back into the quote it was made from.
+func (r Record) Ticker() exchange.TickerGeneral {
+	return exchange.TickerGeneral{
+		InstId:   r.Symbol,
+		Market:   r.Exchange,
+		BidPrice: r.BidPrice,
+		BidSize:  r.BidSize,
+		AskPrice: r.AskPrice,
+		AskSize:  r.AskSize,
+		Time:     r.Time,
+		Received: r.Received,
+		Stale:    r.Stale,
+	}
+}
+
+const (
+	bufferSize    = 65536
+	rotateEvery   = 1 * time.Hour
+	flushEvery    = 1 * time.Second
+	maxFileBytes  = 256 << 20
+	filePrefix    = "ticks-"
+	fileExtension = ".jsonl.gz"
+)
+
+// Recorder writes records from a buffered channel on its own goroutine.
+type Recorder struct {
+	dir     string
+	records chan Record
+	dropped uint64
+	wg      sync.WaitGroup
+
+	mu     sync.RWMutex // Guards closing records against late Record calls
+	closed bool
+
+	file    *os.File
+	gz      *gzip.Writer
+	buf     *bufio.Writer
+	opened  time.Time
+	written int64
+}
+
+// New creates dir if needed and starts the writer.
+func New(dir string) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	r := &Recorder{dir: dir, records: make(chan Record, bufferSize)}
+	r.wg.Add(1)
+	go r.run()
+	return r, nil
+}
+
+// Record queues a quote for writing. It never blocks: when the writer falls
+// behind the quote is dropped and counted instead.
+func (r *Recorder) Record(t exchange.TickerGeneral) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		return
+	}
+	select {
+	case r.records <- FromTicker(t):
+	default:
+		if atomic.AddUint64(&r.dropped, 1)%1000 == 1 {
+			log.Printf("recorder: writer behind, %d records dropped", atomic.LoadUint64(&r.dropped))
+		}
+	}
+}
+
+// Dropped is the number of records lost because the writer fell behind.
+func (r *Recorder) Dropped() uint64 {
+	return atomic.LoadUint64(&r.dropped)
+}
+
+// Close writes the queued records and closes the current file. Later
+// records are ignored.
+func (r *Recorder) Close() {
+	r.mu.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.records)
+	}
+	r.mu.Unlock()
+	r.wg.Wait()
+}
+
+func (r *Recorder) run() {
+	defer r.wg.Done()
+	flush := time.NewTicker(flushEvery)
+	defer flush.Stop()
+
+	for {
+		select {
+		case rec, ok := <-r.records:
+			if !ok {
+				r.closeFile()
+				return
+			}
+			if err := r.write(rec); err != nil {
+				log.Println("recorder:", err)
+			}
+		case <-flush.C:
+			if r.gz != nil {
+				if err := r.buf.Flush(); err != nil {
+					log.Println("recorder:", err)
+				}
+				if err := r.gz.Flush(); err != nil {
+					log.Println("recorder:", err)
+				}
+			}
+		}
+	}
+}
+
+func (r *Recorder) write(rec Record) error {
+	if r.gz == nil || time.Since(r.opened) >= rotateEvery || r.written >= maxFileBytes {
+		r.closeFile()
+		if err := r.openFile(); err != nil {
+			return err
+		}
+	}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	n, err := r.buf.Write(line)
+	r.written += int64(n)
+	return err
+}
+
+func (r *Recorder) openFile() error {
+	now := time.Now().UTC()
+	name := filepath.Join(r.dir, filePrefix+now.Format("20060102-150405")+fileExtension)
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("open %s: %v", name, err)
+	}
+	r.file = f
+	r.gz = gzip.NewWriter(f)
+	r.buf = bufio.NewWriter(r.gz)
+	r.opened = now
+	r.written = 0
+	return nil
+}
+
+func (r *Recorder) closeFile() {
+	if r.gz == nil {
+		return
+	}
+	if err := r.buf.Flush(); err != nil {
+		log.Println("recorder:", err)
+	}
+	if err := r.gz.Close(); err != nil {
+		log.Println("recorder:", err)
+	}
+	if err := r.file.Close(); err != nil {
+		log.Println("recorder:", err)
+	}
+	r.file, r.gz, r.buf = nil, nil, nil
+}
+
+// Files lists the recording files in dir in time order.
+func Files(dir string) ([]string, error) {
+	return filepath.Glob(filepath.Join(dir, filePrefix+"*"+fileExtension))
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"arbitrage/clock"
+	"arbitrage/exchange"
+	"arbitrage/recorder"
+	"arbitrage/replay"
+	"arbitrage/utils"
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+)
+
+// runReplay feeds a recording through the live processing loop. Orders go to
+// dry-run exchanges and the order state to an in-memory database, so nothing
+// reaches the exchanges or the persisted orders.
+func runReplay(dir string, speed float64) {
+	files, err := recorder.Files(dir)
+	if err != nil {
+		log.Fatal("Failed to list recordings:", err)
+	}
+	if len(files) == 0 {
+		log.Fatal("No recordings found in ", dir)
+	}
+
+	db, err := utils.MemoryDatabase()
+	if err != nil {
+		log.Fatal("Failed to open memory database:", err)
+	}
+	defer db.Close()
+
+	exchange.Register(exchange.NewDryRun("BINANCE"))
+	exchange.Register(exchange.NewDryRun("KUCOIN"))
+
+	// The processor moves the clock to every quote as it handles it
+	virtual := clock.NewVirtual(time.Unix(0, 0))
+	clk = virtual
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt)
+	go func() {
+		<-c
+		log.Println("interrupt signal received")
+		cancel()
+	}()
+
+	tickers := make(chan exchange.TickerGeneral)
+	player := &replay.Player{Files: files, Speed: speed}
+	go func() {
+		defer close(tickers)
+		if err := player.Run(ctx, tickers); err != nil {
+			log.Println("replay error:", err)
+		}
+	}()
+
+	p := &processor{db: db, inline: true, virtual: virtual}
+	p.run(tickers)
+	log.Println("replay finished at", virtual.Now().UTC())
+}
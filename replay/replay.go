@@ -0,0 +1,95 @@
+package replay
+
+import (
+	"arbitrage/exchange"
+	"arbitrage/recorder"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// Player feeds recorded quotes back into a tickers channel in their original
+// order. The consumer drives its clock from the recorded receive timestamps.
+type Player struct {
+	Files []string
+	// Speed is the replay rate: 1 replays at the original pace, 10 ten times
+	// faster, and 0 as fast as the consumer accepts quotes.
+	Speed float64
+}
+
+// Run replays every file and returns when they are exhausted or ctx is cancelled.
+func (p *Player) Run(ctx context.Context, tickers chan exchange.TickerGeneral) error {
+	started := time.Now()
+	var first int64
+
+	for _, name := range p.Files {
+		err := Read(name, func(rec recorder.Record) error {
+			if first == 0 {
+				first = rec.Received
+			}
+			if p.Speed > 0 {
+				// Wait until the record is due relative to the start of the replay
+				due := started.Add(time.Duration(float64(rec.Received-first)/p.Speed) * time.Microsecond)
+				if wait := time.Until(due); wait > 0 {
+					select {
+					case <-ctx.Done():
+						return ctx.Err()
+					case <-time.After(wait):
+					}
+				}
+			}
+
+			select {
+			case tickers <- rec.Ticker():
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			return nil
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		log.Println("replayed", name)
+	}
+	return nil
+}
+
+// Read calls fn for every record of a recording file in order.
+func Read(name string, fn func(recorder.Record) error) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	defer gz.Close()
+
+	scanner := bufio.NewScanner(gz)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var rec recorder.Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		// A crash can leave the last gzip block of a file truncated
+		log.Printf("%s: %v", name, err)
+	}
+	return nil
+}
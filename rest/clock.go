@@ -0,0 +1,192 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Signed requests carry a timestamp that both exchanges check against their
+// own clock: KuCoin refuses anything more than 5 seconds off, Binance
+// anything outside the request's recvWindow. The local clock is therefore
+// corrected by the offset to each exchange's server time, sampled
+// periodically.
+
+// clockSamples is how many round trips a sync takes; the fastest one gives
+// the most accurate offset.
+const clockSamples = 3
+
+// serverClock is the offset between the local clock and one exchange's.
+type serverClock struct {
+	name     string
+	endpoint string
+	parse    func(body []byte) (int64, error) // Server time in milliseconds
+
+	mu       sync.Mutex
+	offset   time.Duration // Server time minus local time
+	rtt      time.Duration // Of the sample the offset was taken from
+	syncedAt time.Time
+}
+
+func (c *serverClock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return time.Now().Add(c.offset)
+}
+
+// sync samples the server time and adopts the offset of the sample with
+// the shortest round trip, assuming the server read its clock halfway.
+func (c *serverClock) sync(ctx context.Context, client *http.Client) error {
+	var best time.Duration
+	var bestRTT time.Duration = -1
+	var lastErr error
+	for i := 0; i < clockSamples; i++ {
+		offset, rtt, err := c.sample(ctx, client)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if bestRTT < 0 || rtt < bestRTT {
+			best, bestRTT = offset, rtt
+		}
+	}
+	if bestRTT < 0 {
+		return lastErr
+	}
+
+	c.mu.Lock()
+	c.offset, c.rtt, c.syncedAt = best, bestRTT, time.Now()
+	c.mu.Unlock()
+	if abs(best) > recvWindow()/2 {
+		log.Printf("%s: local clock is %s off the server time", c.name, -best)
+	}
+	return nil
+}
+
+func (c *serverClock) sample(ctx context.Context, client *http.Client) (time.Duration, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.endpoint, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	sent := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	received := time.Now()
+	if err != nil {
+		return 0, 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, 0, fmt.Errorf("%s server time: HTTP %d: %s", c.name, resp.StatusCode, string(body))
+	}
+	ms, err := c.parse(body)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s server time: %v", c.name, err)
+	}
+
+	rtt := received.Sub(sent)
+	local := sent.Add(rtt / 2)
+	return time.UnixMilli(ms).Sub(local), rtt, nil
+}
+
+func abs(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+var binanceClock = &serverClock{
+	name:     "BINANCE",
+	endpoint: BinanceURL + "/api/v3/time",
+	parse: func(body []byte) (int64, error) {
+		var payload struct {
+			ServerTime int64 `json:"serverTime"`
+		}
+		err := json.Unmarshal(body, &payload)
+		return payload.ServerTime, err
+	},
+}
+
+var kucoinClock = &serverClock{
+	name:     "KUCOIN",
+	endpoint: KucoinURL + "/api/v1/timestamp",
+	parse: func(body []byte) (int64, error) {
+		var payload struct {
+			Code string `json:"code"`
+			Data int64  `json:"data"`
+		}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return 0, err
+		}
+		if payload.Code != "200000" {
+			return 0, errors.New(string(body))
+		}
+		return payload.Data, nil
+	},
+}
+
+// SyncClocks samples the server time of both exchanges once.
+func SyncClocks(ctx context.Context) error {
+	var errs []error
+	if err := binanceClock.sync(ctx, Binance); err != nil {
+		errs = append(errs, err)
+	}
+	if err := kucoinClock.sync(ctx, Kucoin); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// KeepSynced resamples the server times every interval until ctx is
+// cancelled. A failed sync keeps the previous offsets.
+func KeepSynced(ctx context.Context, interval time.Duration) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+		if err := SyncClocks(ctx); err != nil && ctx.Err() == nil {
+			log.Println("clock sync:", err)
+		}
+	}
+}
+
+// Offset is how far the server time of exchange is ahead of the local clock.
+func Offset(exchange string) time.Duration {
+	c := binanceClock
+	if exchange == "KUCOIN" {
+		c = kucoinClock
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.offset
+}
+
+func init() {
+	// Served on /debug/vars with the metrics endpoint
+	expvar.Publish("clock", expvar.Func(func() interface{} {
+		drift := make(map[string]interface{})
+		for _, c := range []*serverClock{binanceClock, kucoinClock} {
+			c.mu.Lock()
+			drift[c.name] = map[string]interface{}{
+				"offset_ms": c.offset.Milliseconds(),
+				"rtt_ms":    c.rtt.Milliseconds(),
+				"synced_at": c.syncedAt,
+			}
+			c.mu.Unlock()
+		}
+		return drift
+	}))
+}
@@ -0,0 +1,247 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adshao/go-binance/v2/common"
+)
+
+// Kind tells how an error should be handled.
+type Kind int
+
+const (
+	Unknown             Kind = iota
+	Retryable                // Transient: the same request may succeed later
+	InsufficientBalance      // Not enough funds or borrowable assets
+	Precision                // Price or quantity breaks the symbol's rules
+	RateLimited              // Held back here or refused by the exchange for the request rate
+	Auth                     // Key, signature, permission or timestamp rejected
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Retryable:
+		return "retryable"
+	case InsufficientBalance:
+		return "insufficient balance"
+	case Precision:
+		return "precision"
+	case RateLimited:
+		return "rate limited"
+	case Auth:
+		return "auth"
+	}
+	return "unknown"
+}
+
+// Error is an error response from an exchange.
+type Error struct {
+	Exchange string
+	Status   int    // HTTP status
+	Code     string // Exchange error code; KuCoin codes are strings
+	Msg      string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s error %s (HTTP %d): %s", strings.ToLower(e.Exchange), e.Code, e.Status, e.Msg)
+}
+
+// Kind classifies the error by its code, falling back to the HTTP status.
+func (e *Error) Kind() Kind {
+	var kind Kind
+	if e.Exchange == "BINANCE" {
+		code, _ := strconv.Atoi(e.Code)
+		kind = binanceKind(code, e.Msg)
+	} else {
+		kind = kucoinKind(e.Code, e.Msg)
+	}
+	if kind != Unknown {
+		return kind
+	}
+	switch {
+	case e.Status == http.StatusTooManyRequests || e.Status == http.StatusTeapot:
+		return RateLimited
+	case e.Status == http.StatusUnauthorized || e.Status == http.StatusForbidden:
+		return Auth
+	case e.Status >= 500:
+		return Retryable
+	}
+	return Unknown
+}
+
+func binanceKind(code int, msg string) Kind {
+	switch code {
+	case -1000, -1001, -1006, -1007, -1021: // Unknown, disconnected, unknown send status, timeout, clock drift
+		return Retryable
+	case -1003, -1015: // Too many requests, too many orders
+		return RateLimited
+	case -1013, -1111, -1112: // Filter failure, too much precision
+		return Precision
+	case -1002, -1022, -2008, -2014, -2015: // Unauthorized, bad signature, bad key or permissions
+		return Auth
+	case -3041, -3045: // Margin balance not enough, nothing left to borrow
+		return InsufficientBalance
+	}
+	if strings.Contains(strings.ToLower(msg), "insufficient balance") {
+		return InsufficientBalance
+	}
+	return Unknown
+}
+
+func kucoinKind(code, msg string) Kind {
+	switch code {
+	case "429000":
+		return RateLimited
+	case "500000":
+		return Retryable
+	case "400002": // Timestamp out of the window
+		return Retryable
+	case "400001", "400003", "400004", "400005", "400006", "400007", "411100":
+		return Auth
+	case "200004", "300000":
+		if strings.Contains(strings.ToLower(msg), "balance") {
+			return InsufficientBalance
+		}
+	}
+	lower := strings.ToLower(msg)
+	switch {
+	case strings.Contains(lower, "insufficient") || strings.Contains(lower, "balance insufficient"):
+		return InsufficientBalance
+	case strings.Contains(lower, "increment") || strings.Contains(lower, "precision") || strings.Contains(lower, "min size"):
+		return Precision
+	}
+	return Unknown
+}
+
+// Classify returns the Kind of any error a request can end with: an *Error,
+// a go-binance *common.APIError, a network error or ErrRateLimited.
+func Classify(err error) Kind {
+	if err == nil {
+		return Unknown
+	}
+	if errors.Is(err, ErrRateLimited) {
+		return RateLimited
+	}
+	var exErr *Error
+	if errors.As(err, &exErr) {
+		return exErr.Kind()
+	}
+	var apiErr *common.APIError
+	if errors.As(err, &apiErr) {
+		if apiErr.Code == 0 {
+			return Retryable // go-binance reports a non-JSON body, e.g. a gateway error, with code 0
+		}
+		return binanceKind(int(apiErr.Code), apiErr.Message)
+	}
+	var netErr net.Error
+	var urlErr *url.Error
+	if errors.As(err, &netErr) || errors.As(err, &urlErr) || errors.Is(err, context.DeadlineExceeded) {
+		return Retryable
+	}
+	return Unknown
+}
+
+// Rejected reports whether err shows that the request it came from did not
+// take effect: it was held back by the rate limiter, or the exchange answered
+// it with a refusal. Any other error, such as a timeout, a response that
+// cannot be read or a Binance code saying the outcome is unknown, leaves the
+// outcome open.
+func Rejected(err error) bool {
+	if errors.Is(err, ErrRateLimited) {
+		return true
+	}
+	var exErr *Error
+	if errors.As(err, &exErr) {
+		return exErr.Status < 500
+	}
+	var apiErr *common.APIError
+	if errors.As(err, &apiErr) {
+		// -1021 is retryable, but the request was refused for its timestamp
+		return apiErr.Code == -1021 || apiErr.Code != 0 && binanceKind(int(apiErr.Code), apiErr.Message) != Retryable
+	}
+	return false
+}
+
+// BinanceError returns the error carried by a Binance response, or nil for
+// a successful one.
+func BinanceError(resp *http.Response, body []byte) error {
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	var payload struct {
+		Code int    `json:"code"`
+		Msg  string `json:"msg"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil || payload.Msg == "" {
+		return &Error{Exchange: "BINANCE", Status: resp.StatusCode, Msg: string(body)}
+	}
+	return &Error{Exchange: "BINANCE", Status: resp.StatusCode, Code: strconv.Itoa(payload.Code), Msg: payload.Msg}
+}
+
+// DecodeKucoin checks a KuCoin response and decodes its data into v, which
+// may be nil when the data is not needed.
+func DecodeKucoin(resp *http.Response, body []byte, v interface{}) error {
+	var payload struct {
+		Code string          `json:"code"`
+		Msg  string          `json:"msg"`
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return &Error{Exchange: "KUCOIN", Status: resp.StatusCode, Msg: string(body)}
+	}
+	if resp.StatusCode != http.StatusOK || payload.Code != "200000" {
+		return &Error{Exchange: "KUCOIN", Status: resp.StatusCode, Code: payload.Code, Msg: payload.Msg}
+	}
+	if v == nil || len(payload.Data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(payload.Data, v); err != nil {
+		return fmt.Errorf("kucoin: unexpected response data %s: %v", string(payload.Data), err)
+	}
+	return nil
+}
+
+// Policy is how often and how patiently a call is retried.
+type Policy struct {
+	Attempts int
+	Delay    time.Duration // Before the second attempt, doubling after each
+	MaxDelay time.Duration
+}
+
+// DefaultPolicy is the retry policy of reads and other idempotent calls.
+var DefaultPolicy = Policy{Attempts: 4, Delay: 500 * time.Millisecond, MaxDelay: 10 * time.Second}
+
+// Retry calls fn until it succeeds, fails with an error that is neither
+// Retryable nor RateLimited, or has used up the attempts of p. Only calls
+// that are safe to repeat should be retried.
+func Retry(p Policy, name string, fn func() error) error {
+	delay := p.Delay
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		kind := Classify(err)
+		if err == nil || (kind != Retryable && kind != RateLimited) || attempt >= p.Attempts {
+			return err
+		}
+		wait := delay
+		if kind == RateLimited && wait < time.Second {
+			wait = time.Second
+		}
+		log.Printf("%s: %v (%s), retrying in %s", name, err, kind, wait)
+		time.Sleep(wait)
+		delay *= 2
+		if delay > p.MaxDelay {
+			delay = p.MaxDelay
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package rest
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/adshao/go-binance/v2/common"
+)
+
+func TestRejected(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"held back by the limiter", fmt.Errorf("binance: %w", ErrRateLimited), true},
+		{"kucoin refusal", &Error{Exchange: "KUCOIN", Status: 400, Code: "200004", Msg: "Balance insufficient"}, true},
+		{"binance server error", &Error{Exchange: "BINANCE", Status: 503, Msg: "Service Unavailable"}, false},
+		{"go-binance refusal", &common.APIError{Code: -2010, Message: "Account has insufficient balance"}, true},
+		{"go-binance filter failure", fmt.Errorf("repay: %w", &common.APIError{Code: -1013, Message: "Filter failure: LOT_SIZE"}), true},
+		{"go-binance timestamp refusal", &common.APIError{Code: -1021, Message: "Timestamp outside of the recvWindow"}, true},
+		{"go-binance unknown outcome", &common.APIError{Code: -1006, Message: "Unexpected response"}, false},
+		{"go-binance timeout", &common.APIError{Code: -1007, Message: "Timeout waiting for response"}, false},
+		{"go-binance unreadable body", &common.APIError{Code: 0, Message: "<html>502</html>"}, false},
+		{"network error", errors.New("connection reset by peer"), false},
+	}
+	for _, tt := range tests {
+		if got := Rejected(tt.err); got != tt.want {
+			t.Errorf("%s: Rejected(%v) = %v, want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package rest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is returned for an order that cannot be sent without
+// exceeding the exchange's limits. Orders fail fast rather than wait, since
+// the prices they were computed at would be gone by then.
+var ErrRateLimited = errors.New("rate limit reached")
+
+// orderReserve is the share of every weight pool that only order placement,
+// cancels and order queries may use, so that background polling cannot
+// crowd out trading.
+const orderReserve = 0.2
+
+// pool is one budget of request weight that the exchange resets at
+// intervals.
+type pool struct {
+	name     string
+	header   string // Response header reporting the weight used, if any
+	capacity int
+	interval time.Duration
+	used     int
+	resetAt  time.Time
+}
+
+func (p *pool) roll(now time.Time) {
+	if !now.Before(p.resetAt) {
+		p.used = 0
+		// Binance counts per clock interval, not from the first request
+		p.resetAt = now.Truncate(p.interval).Add(p.interval)
+	}
+}
+
+// limiter is an http.RoundTripper that keeps the requests of one exchange
+// within its weight pools.
+type limiter struct {
+	name string
+	next http.RoundTripper
+
+	mu          sync.Mutex
+	bannedUntil time.Time
+	pools       map[string]*pool
+	// cost returns the pools req draws on, with the weight drawn from each,
+	// and whether it is part of placing or following an order.
+	cost func(req *http.Request) (map[string]int, bool)
+	// observe updates the pools from the headers of a response.
+	observe func(l *limiter, resp *http.Response, now time.Time)
+}
+
+func (l *limiter) RoundTrip(req *http.Request) (*http.Response, error) {
+	weights, priority := l.cost(req)
+	if err := l.acquire(req.Context(), weights, priority); err != nil {
+		return nil, fmt.Errorf("%s %s: %w", l.name, req.URL.Path, err)
+	}
+	resp, err := l.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	l.mu.Lock()
+	l.observe(l, resp, now)
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusTeapot {
+		wait := retryAfter(resp, time.Minute)
+		if until := now.Add(wait); until.After(l.bannedUntil) {
+			l.bannedUntil = until
+		}
+		log.Printf("%s: HTTP %d on %s, holding requests for %s", l.name, resp.StatusCode, req.URL.Path, wait)
+	}
+	l.mu.Unlock()
+	return resp, nil
+}
+
+// acquire reserves weights, waiting for the pools to reset when they are
+// spent. Orders never wait: they either fit or fail.
+func (l *limiter) acquire(ctx context.Context, weights map[string]int, priority bool) error {
+	for {
+		now := time.Now()
+		l.mu.Lock()
+		wait := l.bannedUntil.Sub(now)
+		if wait <= 0 {
+			wait = 0
+			for name, weight := range weights {
+				p, ok := l.pools[name]
+				if !ok {
+					continue
+				}
+				p.roll(now)
+				limit := p.capacity
+				if !priority {
+					limit -= int(float64(p.capacity) * orderReserve)
+				}
+				if p.used+weight > limit {
+					if w := p.resetAt.Sub(now); w > wait {
+						wait = w
+					}
+				}
+			}
+		}
+		if wait == 0 {
+			for name, weight := range weights {
+				if p, ok := l.pools[name]; ok {
+					p.used += weight
+				}
+			}
+			l.mu.Unlock()
+			return nil
+		}
+		l.mu.Unlock()
+
+		if priority {
+			return ErrRateLimited
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// report sets the weight used in a pool as the exchange counted it. It only
+// raises the local count, which also holds requests still in flight.
+func (l *limiter) report(name string, used int, now time.Time) {
+	p, ok := l.pools[name]
+	if !ok {
+		return
+	}
+	p.roll(now)
+	if used > p.used {
+		p.used = used
+	}
+}
+
+// Usage is the state of one weight pool.
+type Usage struct {
+	Exchange string
+	Pool     string
+	Used     int
+	Capacity int
+	ResetAt  time.Time
+}
+
+// Usages returns the state of every weight pool of both exchanges.
+func Usages() []Usage {
+	var usages []Usage
+	for _, l := range []*limiter{binanceLimiter, kucoinLimiter} {
+		l.mu.Lock()
+		for _, p := range l.pools {
+			usages = append(usages, Usage{Exchange: l.name, Pool: p.name, Used: p.used, Capacity: p.capacity, ResetAt: p.resetAt})
+		}
+		l.mu.Unlock()
+	}
+	return usages
+}
+
+func retryAfter(resp *http.Response, fallback time.Duration) time.Duration {
+	if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && s > 0 {
+		return time.Duration(s) * time.Second
+	}
+	return fallback
+}
+
+func headerInt(resp *http.Response, name string) (int, bool) {
+	v, err := strconv.Atoi(resp.Header.Get(name))
+	return v, err == nil
+}
+
+// Binance counts weight per IP separately for /api and /sapi endpoints, and
+// orders per account in 10 second intervals.
+var binanceLimiter = &limiter{
+	name: "BINANCE",
+	pools: map[string]*pool{
+		"api":    {name: "api", header: "X-Mbx-Used-Weight-1m", capacity: 6000, interval: time.Minute},
+		"sapi":   {name: "sapi", header: "X-Sapi-Used-Ip-Weight-1m", capacity: 12000, interval: time.Minute},
+		"orders": {name: "orders", header: "X-Mbx-Order-Count-10s", capacity: 100, interval: 10 * time.Second},
+	},
+	cost:    binanceCost,
+	observe: binanceObserve,
+}
+
+// binanceWeights are the weights of the endpoints used that cost more than 1.
+var binanceWeights = map[string]int{
+	"GET /api/v3/order":                  4,
+	"GET /api/v3/openOrders":             6,
+	"GET /api/v3/account":                20,
+	"GET /api/v3/myTrades":               20,
+	"GET /api/v3/exchangeInfo":           20,
+	"GET /sapi/v1/margin/order":          10,
+	"GET /sapi/v1/margin/openOrders":     10,
+	"GET /sapi/v1/margin/myTrades":       10,
+	"GET /sapi/v1/margin/account":        10,
+	"GET /sapi/v1/capital/config/getall": 10,
+}
+
+func binanceCost(req *http.Request) (map[string]int, bool) {
+	path := req.URL.Path
+	weight, ok := binanceWeights[req.Method+" "+path]
+	if !ok {
+		weight = 1
+	}
+	if strings.HasPrefix(path, "/api/v3/openOrders") && req.URL.Query().Get("symbol") == "" {
+		weight = 80 // Every symbol
+	}
+	if path == "/api/v3/depth" {
+		weight = binanceDepthWeight(req.URL.Query().Get("limit"))
+	}
+	priority := strings.HasSuffix(path, "/order") || strings.HasSuffix(path, "/openOrders") ||
+		strings.HasSuffix(path, "/myTrades")
+
+	pool := "api"
+	if strings.HasPrefix(path, "/sapi/") {
+		pool = "sapi"
+	}
+	weights := map[string]int{pool: weight}
+	if req.Method == http.MethodPost && strings.HasSuffix(path, "/order") {
+		weights["orders"] = 1
+	}
+	return weights, priority
+}
+
+// binanceDepthWeight returns the weight of an order book snapshot of limit
+// levels, 100 when not given.
+func binanceDepthWeight(limit string) int {
+	levels, err := strconv.Atoi(limit)
+	if err != nil {
+		levels = 100
+	}
+	switch {
+	case levels <= 100:
+		return 5
+	case levels <= 500:
+		return 25
+	case levels <= 1000:
+		return 50
+	default:
+		return 250
+	}
+}
+
+func binanceObserve(l *limiter, resp *http.Response, now time.Time) {
+	for _, p := range l.pools {
+		if used, ok := headerInt(resp, p.header); ok {
+			l.report(p.name, used, now)
+		}
+	}
+}
+
+// KuCoin keeps separate quotas for public and private endpoints and reports
+// the limit, the remaining quota and the milliseconds until it resets with
+// every response; the numbers below only hold until the first response.
+var kucoinLimiter = &limiter{
+	name: "KUCOIN",
+	pools: map[string]*pool{
+		"public":  {name: "public", capacity: 2000, interval: 30 * time.Second},
+		"private": {name: "private", capacity: 4000, interval: 30 * time.Second},
+	},
+	cost:    kucoinCost,
+	observe: kucoinObserve,
+}
+
+// kucoinWeights are the weights of the endpoints used that cost more than 2.
+var kucoinWeights = map[string]int{
+	"GET /api/v1/accounts":      5,
+	"GET /api/v1/fills":         10,
+	"GET /api/v2/symbols":       4,
+	"POST /api/v1/margin/order": 5,
+}
+
+func kucoinCost(req *http.Request) (map[string]int, bool) {
+	path := req.URL.Path
+	weight, ok := kucoinWeights[req.Method+" "+path]
+	if !ok {
+		weight = 2 // As /api/v1/market/orderbook/level2_100 costs
+	}
+	if strings.HasPrefix(path, "/api/v3/currencies/") {
+		weight = 3 // Any currency
+	}
+	// Placing, cancelling and looking up orders, and their fills; not the order book
+	priority := strings.HasPrefix(path, "/api/v1/orders") || strings.HasPrefix(path, "/api/v1/order/") ||
+		path == "/api/v1/margin/order" || strings.HasPrefix(path, "/api/v1/fills")
+	return map[string]int{kucoinPool(req): weight}, priority
+}
+
+func kucoinPool(req *http.Request) string {
+	if req.Header.Get("KC-API-KEY") == "" {
+		return "public"
+	}
+	return "private"
+}
+
+func kucoinObserve(l *limiter, resp *http.Response, now time.Time) {
+	limit, ok1 := headerInt(resp, "gw-ratelimit-limit")
+	remaining, ok2 := headerInt(resp, "gw-ratelimit-remaining")
+	reset, ok3 := headerInt(resp, "gw-ratelimit-reset")
+	if !ok1 || !ok2 || !ok3 {
+		return
+	}
+	p := l.pools[kucoinPool(resp.Request)]
+	p.capacity = limit
+	p.used = limit - remaining
+	p.resetAt = now.Add(time.Duration(reset) * time.Millisecond)
+	if resp.StatusCode == http.StatusTooManyRequests && resp.Header.Get("Retry-After") == "" {
+		resp.Header.Set("Retry-After", strconv.Itoa(reset/1000+1))
+	}
+}
@@ -0,0 +1,40 @@
+package rest
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestKucoinCost(t *testing.T) {
+	tests := []struct {
+		method, url string
+		signed      bool
+		pool        string
+		weight      int
+		priority    bool
+	}{
+		{"POST", "/api/v1/orders", true, "private", 2, true},
+		{"DELETE", "/api/v1/orders/5c35c02703aa673ceec2a168", true, "private", 2, true},
+		{"GET", "/api/v1/order/client-order/arb-1", true, "private", 2, true},
+		{"POST", "/api/v1/margin/order", true, "private", 5, true},
+		{"GET", "/api/v1/fills?orderId=1", true, "private", 10, true},
+		{"GET", "/api/v1/market/orderbook/level2_100?symbol=BTC-USDT", false, "public", 2, false},
+		{"GET", "/api/v3/currencies/USDT", false, "public", 3, false},
+		{"GET", "/api/v2/symbols", false, "public", 4, false},
+		{"GET", "/api/v1/accounts", true, "private", 5, false},
+		{"POST", "/api/v1/margin/repay", true, "private", 2, false},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest(tt.method, KucoinURL+tt.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.signed {
+			req.Header.Set("KC-API-KEY", "key")
+		}
+		weights, priority := kucoinCost(req)
+		if weights[tt.pool] != tt.weight || len(weights) != 1 || priority != tt.priority {
+			t.Errorf("%s %s: cost %v, priority %v, want %s %d, priority %v", tt.method, tt.url, weights, priority, tt.pool, tt.weight, tt.priority)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+// Package rest holds the HTTP clients and request signing shared by every
+// REST call to the exchanges. Each exchange gets one client whose keep-alive
+// pool is reused across the order, transfer and balance packages, so that
+// orders do not pay for a fresh TCP and TLS handshake, and whose requests
+// are kept within the exchange's rate limits.
+package rest
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+)
+
+const (
+	BinanceURL = "https://api.binance.com"
+	KucoinURL  = "https://api.kucoin.com"
+)
+
+// Config holds the timeouts and pool size of the shared clients.
+type Config struct {
+	Timeout             time.Duration // Whole request, reading the response included
+	DialTimeout         time.Duration
+	TLSHandshakeTimeout time.Duration
+	IdleConnTimeout     time.Duration // How long an unused connection stays in the pool
+	MaxIdleConnsPerHost int           // Idle connections kept per host
+	RecvWindow          time.Duration // How late a signed Binance request may arrive, at most maxRecvWindow
+}
+
+// DefaultConfig is applied at startup.
+var DefaultConfig = Config{
+	Timeout:             10 * time.Second,
+	DialTimeout:         5 * time.Second,
+	TLSHandshakeTimeout: 5 * time.Second,
+	IdleConnTimeout:     90 * time.Second,
+	MaxIdleConnsPerHost: 16,
+	RecvWindow:          5 * time.Second,
+}
+
+// The shared clients, one per exchange.
+var (
+	Binance = &http.Client{}
+	Kucoin  = &http.Client{}
+)
+
+var config Config
+
+func init() {
+	Configure(DefaultConfig)
+}
+
+// maxRecvWindow is the longest recvWindow Binance accepts.
+const maxRecvWindow = time.Minute
+
+// Configure applies cfg to both clients. It must be called before the first
+// request, as the clients are not reconfigured while in use. A RecvWindow
+// Binance would reject is clamped to the range it accepts.
+func Configure(cfg Config) {
+	switch {
+	case cfg.RecvWindow > maxRecvWindow:
+		log.Printf("recvWindow %v is above Binance's maximum, using %v", cfg.RecvWindow, maxRecvWindow)
+		cfg.RecvWindow = maxRecvWindow
+	case cfg.RecvWindow <= 0:
+		log.Printf("recvWindow %v is not positive, using %v", cfg.RecvWindow, DefaultConfig.RecvWindow)
+		cfg.RecvWindow = DefaultConfig.RecvWindow
+	}
+	config = cfg
+	binanceLimiter.next, kucoinLimiter.next = transport(cfg), transport(cfg)
+	Binance.Transport, Binance.Timeout = binanceLimiter, cfg.Timeout
+	Kucoin.Transport, Kucoin.Timeout = kucoinLimiter, cfg.Timeout
+}
+
+func transport(cfg Config) *http.Transport {
+	dialer := &net.Dialer{Timeout: cfg.DialTimeout, KeepAlive: 30 * time.Second}
+	return &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         dialer.DialContext,
+		ForceAttemptHTTP2:   true,
+		TLSHandshakeTimeout: cfg.TLSHandshakeTimeout,
+		IdleConnTimeout:     cfg.IdleConnTimeout,
+		MaxIdleConns:        2 * cfg.MaxIdleConnsPerHost,
+		MaxIdleConnsPerHost: cfg.MaxIdleConnsPerHost,
+	}
+}
+
+var (
+	binanceOnce   sync.Once
+	binanceClient *binance.Client
+)
+
+// BinanceClient returns the go-binance client, on top of the shared Binance
+// client, that the connectors use for the endpoints the library covers. The
+// client is a copy carrying the current clock offset, so it should not be
+// kept beyond the call at hand.
+func BinanceClient() *binance.Client {
+	binanceOnce.Do(func() {
+		binanceClient = binance.NewClient(os.Getenv("BINANCE_API_KEY"), os.Getenv("BINANCE_API_SECRET"))
+		binanceClient.HTTPClient = Binance
+	})
+	client := *binanceClient
+	client.TimeOffset = -Offset("BINANCE").Milliseconds() // The library subtracts it from the local time
+	return &client
+}
+
+// RecvWindow is the option giving a signed go-binance request the
+// configured recvWindow.
+func RecvWindow() binance.RequestOption {
+	return binance.WithRecvWindow(recvWindow().Milliseconds())
+}
+
+func recvWindow() time.Duration {
+	return config.RecvWindow
+}
+
+// SignBinance adds the recvWindow, timestamp and signature a signed Binance
+// endpoint requires to params. Nothing may be added to params afterwards.
+func SignBinance(params url.Values) {
+	params.Set("recvWindow", strconv.FormatInt(recvWindow().Milliseconds(), 10))
+	params.Set("timestamp", strconv.FormatInt(binanceClock.now().UnixMilli(), 10))
+	h := hmac.New(sha256.New, []byte(os.Getenv("BINANCE_API_SECRET")))
+	h.Write([]byte(params.Encode()))
+	params.Set("signature", hex.EncodeToString(h.Sum(nil)))
+}
+
+// SignKucoin sets the authentication headers of a KuCoin request. endpoint
+// is the path including the query string, body the exact payload sent.
+func SignKucoin(req *http.Request, endpoint, body string) {
+	secret := os.Getenv("KUCOIN_API_SECRET")
+	timestamp := strconv.FormatInt(kucoinClock.now().UnixMilli(), 10)
+	req.Header.Set("KC-API-KEY", os.Getenv("KUCOIN_API_KEY"))
+	req.Header.Set("KC-API-SIGN", kucoinHmac(secret, timestamp+req.Method+endpoint+body))
+	req.Header.Set("KC-API-TIMESTAMP", timestamp)
+	req.Header.Set("KC-API-PASSPHRASE", kucoinHmac(secret, os.Getenv("KUCOIN_API_PASSPHRASE")))
+	req.Header.Set("KC-API-KEY-VERSION", "2")
+}
+
+func kucoinHmac(secret, message string) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(message))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// warmInterval keeps pooled connections from reaching IdleConnTimeout.
+const warmInterval = 30 * time.Second
+
+// KeepWarm opens connections to both exchanges right away, before the first
+// order needs one, and touches them periodically until ctx is cancelled.
+func KeepWarm(ctx context.Context, conns int) {
+	for {
+		warm(ctx, Binance, BinanceURL+"/api/v3/ping", conns)
+		warm(ctx, Kucoin, KucoinURL+"/api/v1/timestamp", conns)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(warmInterval):
+		}
+	}
+}
+
+// warm sends conns concurrent requests, so that as many connections end up
+// in the pool.
+func warm(ctx context.Context, client *http.Client, endpoint string, conns int) {
+	var wg sync.WaitGroup
+	for i := 0; i < conns; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+			if err != nil {
+				return
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Println("warm up:", err)
+				}
+				return
+			}
+			io.Copy(io.Discard, resp.Body) // A drained body returns the connection to the pool
+			resp.Body.Close()
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,26 @@
+package rest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConfigureClampsRecvWindow(t *testing.T) {
+	t.Cleanup(func() { Configure(DefaultConfig) })
+
+	tests := []struct{ set, want time.Duration }{
+		{10 * time.Second, 10 * time.Second},
+		{time.Minute, time.Minute},
+		{2 * time.Minute, time.Minute},
+		{0, DefaultConfig.RecvWindow},
+		{-time.Second, DefaultConfig.RecvWindow},
+	}
+	for _, tt := range tests {
+		cfg := DefaultConfig
+		cfg.RecvWindow = tt.set
+		Configure(cfg)
+		if got := recvWindow(); got != tt.want {
+			t.Errorf("RecvWindow %v: configured %v, want %v", tt.set, got, tt.want)
+		}
+	}
+}
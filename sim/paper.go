@@ -0,0 +1,130 @@
+package sim
+
+import (
+	"arbitrage/clock"
+	"arbitrage/exchange"
+	"encoding/json"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// NewPaper simulates trading on top of a live exchange: quotes and order
+// books come from feed, while orders, transfers and balances stay in the
+// simulation.
+func NewPaper(feed exchange.Exchange, cfg Config) *Exchange {
+	e := New(feed.Name(), cfg, NewLive(), clock.Real{})
+	e.feed = feed
+	return e
+}
+
+// Live holds the latest quote of every symbol of a live stream.
+type Live struct {
+	mu     sync.RWMutex
+	quotes map[string]Quote // InstId -> Quote
+}
+
+func NewLive() *Live {
+	return &Live{quotes: make(map[string]Quote)}
+}
+
+// Update stores a quote from the stream. A disconnect drops every quote.
+func (l *Live) Update(ticker exchange.TickerGeneral) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if ticker.Stale {
+		l.quotes = make(map[string]Quote)
+		return
+	}
+	q := Quote{Time: time.UnixMicro(ticker.Received)}
+	var err error
+	if q.Bid, err = strconv.ParseFloat(ticker.BidPrice, 64); err != nil {
+		return
+	}
+	if q.BidSize, err = strconv.ParseFloat(ticker.BidSize, 64); err != nil {
+		return
+	}
+	if q.Ask, err = strconv.ParseFloat(ticker.AskPrice, 64); err != nil {
+		return
+	}
+	if q.AskSize, err = strconv.ParseFloat(ticker.AskSize, 64); err != nil {
+		return
+	}
+	l.quotes[ticker.InstId] = q
+}
+
+// Quote waits until at, so that an order placed now sees the market after
+// its latency, and returns the latest quote of instId.
+func (l *Live) Quote(instId string, at time.Time) (Quote, bool) {
+	if wait := time.Until(at); wait > 0 {
+		time.Sleep(wait)
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	q, ok := l.quotes[instId]
+	return q, ok
+}
+
+// state is what Persist keeps of an exchange.
+type state struct {
+	Wallets map[string]map[string]float64 `json:"wallets"`
+	Loans   map[string]*loan              `json:"loans"`
+	Pending []arrival                     `json:"pending"`
+	Arrived []arrival                     `json:"arrived,omitempty"`
+	NextId  int                           `json:"nextId"`
+}
+
+// Persist restores the wallets saved in db, if any, and saves them after
+// every change from then on. It reports whether state was restored.
+func (e *Exchange) Persist(db *leveldb.DB) (bool, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.db = db
+
+	data, err := db.Get(e.key(), nil)
+	if err == leveldb.ErrNotFound {
+		e.save()
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	var s state
+	if err := json.Unmarshal(data, &s); err != nil {
+		return false, err
+	}
+	for wallet, assets := range s.Wallets {
+		if e.wallets[wallet] != nil && assets != nil {
+			e.wallets[wallet] = assets
+		}
+	}
+	if s.Loans != nil {
+		e.loans = s.Loans
+	}
+	e.pending = s.Pending
+	e.arrived = s.Arrived
+	e.nextId = s.NextId
+	return true, nil
+}
+
+// save writes the state when persisting. The caller holds e.mu.
+func (e *Exchange) save() {
+	if e.db == nil {
+		return
+	}
+	data, err := json.Marshal(state{Wallets: e.wallets, Loans: e.loans, Pending: e.pending, Arrived: e.arrived, NextId: e.nextId})
+	if err != nil {
+		log.Println(e.name, "paper state:", err)
+		return
+	}
+	if err := e.db.Put(e.key(), data, nil); err != nil {
+		log.Println(e.name, "paper state:", err)
+	}
+}
+
+func (e *Exchange) key() []byte {
+	return []byte("paper/" + e.name)
+}
@@ -0,0 +1,567 @@
+// Package sim is an in-process exchange for trying the strategy without
+// real money. It keeps spot, margin and funding wallets and margin loans,
+// and fills market orders against quotes supplied by the caller.
+package sim
+
+import (
+	"arbitrage/balance"
+	"arbitrage/clock"
+	"arbitrage/exchange"
+	"arbitrage/order"
+	"arbitrage/orderbook"
+	"arbitrage/transfer"
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// Config describes the trading costs the simulation charges.
+type Config struct {
+	TakerFee float64 // Fraction of the notional charged on every fill, in the quote asset
+	// Impact is how much worse each further top-of-book size of an order
+	// fills, as a fraction of the quoted price. Recordings only hold the top
+	// of book, so this stands in for the depth behind it.
+	Impact        float64
+	Latency       time.Duration // Between placing an order and its fill
+	BorrowRate    float64       // Margin interest per hour, as a fraction of the loan
+	TransferDelay time.Duration // Until a withdrawal is credited on the receiving exchange
+}
+
+// Quote is the top of book of one symbol.
+type Quote struct {
+	Bid, BidSize float64
+	Ask, AskSize float64
+	Time         time.Time
+}
+
+// Quotes supplies the quote of a symbol in effect at a given time.
+type Quotes interface {
+	Quote(instId string, at time.Time) (Quote, bool)
+}
+
+// Fill is one executed order.
+type Fill struct {
+	Time     time.Time
+	Exchange string
+	InstId   string
+	Wallet   string
+	Side     string
+	Qty      float64
+	Price    float64
+	Fee      float64 // In the quote asset
+}
+
+type loan struct {
+	Principal float64
+	Interest  float64
+	Since     time.Time
+}
+
+type arrival struct {
+	Asset  string
+	Amount float64
+	At     time.Time
+	TxId   string // The ID of the withdrawal, standing in for the transaction
+}
+
+const quoteAsset = "USDT"
+
+// Exchange simulates one venue and implements exchange.Exchange.
+type Exchange struct {
+	name   string
+	cfg    Config
+	quotes Quotes
+	clock  clock.Clock
+
+	mu      sync.Mutex
+	wallets map[string]map[string]float64 // Wallet -> Asset -> Balance
+	loans   map[string]*loan              // Asset -> Loan
+	pending []arrival
+	arrived []arrival // Credited, for lookups by transaction
+	fills   []Fill
+	marks   map[string]float64 // Asset -> Last known mid price
+	nextId  int
+
+	feed exchange.Exchange // Live exchange streaming the quotes in paper mode
+	db   *leveldb.DB       // Persists the wallets when set
+
+	// OnFill, when set, runs after every fill outside the exchange lock.
+	OnFill func(Fill)
+}
+
+func New(name string, cfg Config, quotes Quotes, clk clock.Clock) *Exchange {
+	return &Exchange{
+		name:   name,
+		cfg:    cfg,
+		quotes: quotes,
+		clock:  clk,
+		wallets: map[string]map[string]float64{
+			exchange.SPOT:    make(map[string]float64),
+			exchange.MARGIN:  make(map[string]float64),
+			exchange.FUNDING: make(map[string]float64),
+		},
+		loans: make(map[string]*loan),
+		marks: make(map[string]float64),
+	}
+}
+
+// Deposit credits amount of asset to wallet straight away, for seeding balances.
+func (e *Exchange) Deposit(wallet, asset string, amount float64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.wallets[wallet][asset] += amount
+	e.save()
+}
+
+func (e *Exchange) Name() string {
+	return e.name
+}
+
+// Stream forwards the quotes of the live exchange in paper mode and does
+// nothing otherwise; the caller then feeds the quotes.
+func (e *Exchange) Stream(ctx context.Context, tickers chan exchange.TickerGeneral) {
+	if e.feed == nil {
+		return
+	}
+	live := e.quotes.(*Live)
+	feed := make(chan exchange.TickerGeneral)
+	go func() {
+		e.feed.Stream(ctx, feed)
+		close(feed)
+	}()
+	for ticker := range feed {
+		live.Update(ticker)
+		select {
+		case tickers <- ticker:
+		case <-ctx.Done():
+		}
+	}
+}
+
+// Book returns the live order book in paper mode.
+func (e *Exchange) Book(instId string) *orderbook.Book {
+	if e.feed == nil {
+		return nil
+	}
+	return e.feed.Book(instId)
+}
+
+// Order buys instId with the spot wallet or shorts it with the margin
+// wallet, borrowing whatever base asset the margin wallet lacks.
+func (e *Exchange) Order(marketType, instId string, quantity float64) (order.OrderResult, error) {
+	if marketType == exchange.MARGIN {
+		return e.trade(exchange.MARGIN, instId, order.Sell, quantity, 0, "")
+	}
+	return e.trade(exchange.SPOT, instId, order.Buy, quantity, 0, "")
+}
+
+// Reverse sells the spot position or buys back the short.
+func (e *Exchange) Reverse(marketType, instId string, quantity float64) (order.OrderResult, error) {
+	if marketType == exchange.MARGIN {
+		return e.trade(exchange.MARGIN, instId, order.Buy, quantity, 0, "")
+	}
+	return e.trade(exchange.SPOT, instId, order.Sell, quantity, 0, "")
+}
+
+// Limit fills IOC and FOK orders against the quote up to price. Orders that
+// would rest on the book are not simulated.
+func (e *Exchange) Limit(marketType, side, instId string, price, quantity float64, timeInForce string) (order.OrderResult, error) {
+	if timeInForce != order.IOC && timeInForce != order.FOK {
+		return order.OrderResult{}, fmt.Errorf("%s: %s limit orders are not simulated", e.name, timeInForce)
+	}
+	if price <= 0 {
+		return order.OrderResult{}, fmt.Errorf("%s: invalid price %f", e.name, price)
+	}
+	wallet := exchange.SPOT
+	if marketType == exchange.MARGIN {
+		wallet = exchange.MARGIN
+	}
+	result, err := e.trade(wallet, instId, side, quantity, price, timeInForce)
+	if err == nil && !result.Filled() {
+		err = &order.FillError{Result: result}
+	}
+	return result, err
+}
+
+// Cancel returns result unchanged, every simulated order is final once placed.
+func (e *Exchange) Cancel(result order.OrderResult) (order.OrderResult, error) {
+	return result, nil
+}
+
+// trade fills a market order, or a limit order when limit is set, as far as
+// the quote allows.
+func (e *Exchange) trade(wallet, instId, side string, qty, limit float64, timeInForce string) (order.OrderResult, error) {
+	if qty <= 0 {
+		return order.OrderResult{}, fmt.Errorf("%s: invalid quantity %f", e.name, qty)
+	}
+	base, quote, ok := strings.Cut(instId, "-")
+	if !ok {
+		return order.OrderResult{}, fmt.Errorf("%s: invalid symbol %s", e.name, instId)
+	}
+	result := order.OrderResult{
+		Exchange:    e.name,
+		InstId:      instId,
+		Side:        side,
+		Type:        order.Market,
+		MarketType:  wallet,
+		TimeInForce: timeInForce,
+		Quantity:    qty,
+		FeeAsset:    quote,
+	}
+	if limit > 0 {
+		result.Type = order.Limit
+	}
+
+	// The order reaches the exchange after the latency and fills against the book at that time
+	at := e.clock.Now().Add(e.cfg.Latency)
+	q, ok := e.quotes.Quote(instId, at)
+	if !ok {
+		return order.OrderResult{}, fmt.Errorf("%s: no quote for %s", e.name, instId)
+	}
+	bookSide := orderbook.Ask
+	if side == order.Sell {
+		bookSide = orderbook.Bid
+	}
+	book := e.Book(instId)
+	if book != nil && !book.Synced() {
+		book = nil
+	}
+
+	fillQty := qty
+	if limit > 0 {
+		available := e.cfg.available(q, side, limit)
+		if book != nil {
+			available = book.Available(bookSide, limit)
+		}
+		if available < qty && (timeInForce == order.FOK || available <= 0) {
+			result.Status = order.StatusExpired
+			return result, nil
+		}
+		fillQty = math.Min(qty, available)
+	}
+
+	price := e.cfg.price(q, side, fillQty)
+	if book != nil {
+		// Walk the live book when it is deep enough
+		if vwap, filled := book.FillQuantity(bookSide, fillQty); filled >= fillQty {
+			price = vwap
+		}
+	}
+	if price <= 0 {
+		return order.OrderResult{}, fmt.Errorf("%s: no %s liquidity for %s", e.name, side, instId)
+	}
+	fill, id, err := e.execute(wallet, base, quote, side, fillQty, price, at)
+	if err != nil {
+		return order.OrderResult{}, err
+	}
+	if e.OnFill != nil {
+		e.OnFill(fill)
+	}
+	result.ClientId, result.OrderId = id, id
+	result.Status = order.StatusFilled
+	if fillQty < qty {
+		result.Status = order.StatusExpired // The rest of an IOC order is cancelled
+	}
+	result.ExecutedQty = fillQty
+	result.AvgPrice = price
+	result.Fee = fill.Fee
+	return result, nil
+}
+
+func (e *Exchange) execute(wallet, base, quote, side string, qty, price float64, at time.Time) (Fill, string, error) {
+	notional := price * qty
+	fee := notional * e.cfg.TakerFee
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.settle()
+	balances := e.wallets[wallet]
+	if side == order.Buy {
+		if balances[quote] < notional+fee {
+			return Fill{}, "", fmt.Errorf("%s: insufficient %s %s balance: %f < %f", e.name, wallet, quote, balances[quote], notional+fee)
+		}
+		balances[quote] -= notional + fee
+		balances[base] += qty
+	} else {
+		if balances[base] < qty {
+			if wallet != exchange.MARGIN {
+				return Fill{}, "", fmt.Errorf("%s: insufficient %s %s balance: %f < %f", e.name, wallet, base, balances[base], qty)
+			}
+			e.borrow(base, qty-balances[base], at)
+		}
+		balances[base] -= qty
+		balances[quote] += notional - fee
+	}
+	fill := Fill{Time: at, Exchange: e.name, InstId: base + "-" + quote, Wallet: wallet, Side: side, Qty: qty, Price: price, Fee: fee}
+	e.fills = append(e.fills, fill)
+	id := e.id()
+	e.save()
+	return fill, id, nil
+}
+
+// price is the average price of a market order of qty. The quoted size fills
+// at the quote and every further quoted size one Impact step worse.
+func (c Config) price(q Quote, side string, qty float64) float64 {
+	top, size, direction := q.Ask, q.AskSize, 1.0
+	if side == order.Sell {
+		top, size, direction = q.Bid, q.BidSize, -1.0
+	}
+	if size <= 0 || qty <= size {
+		return top
+	}
+	levels := math.Floor(qty / size)
+	rest := qty - levels*size
+	cost := size * top * (levels + direction*c.Impact*levels*(levels-1)/2)
+	cost += rest * top * (1 + direction*c.Impact*levels)
+	return math.Max(cost/qty, 0)
+}
+
+// available is how much of an order at limit fills under the same model as
+// price: nothing when the quote is worse than limit, otherwise the quoted
+// size for every Impact step that stays within limit.
+func (c Config) available(q Quote, side string, limit float64) float64 {
+	top, size := q.Ask, q.AskSize
+	slack := limit/top - 1
+	if side == order.Sell {
+		top, size = q.Bid, q.BidSize
+		slack = 1 - limit/top
+	}
+	if top <= 0 || slack < 0 {
+		return 0
+	}
+	if size <= 0 || c.Impact <= 0 {
+		return math.Inf(1)
+	}
+	return (math.Floor(slack/c.Impact+1e-9) + 1) * size
+}
+
+func (e *Exchange) borrow(asset string, amount float64, at time.Time) {
+	l, ok := e.loans[asset]
+	if !ok {
+		l = &loan{Since: at}
+		e.loans[asset] = l
+	}
+	e.accrue(l, at)
+	l.Principal += amount
+	e.wallets[exchange.MARGIN][asset] += amount
+}
+
+func (e *Exchange) accrue(l *loan, now time.Time) {
+	if now.After(l.Since) {
+		l.Interest += l.Principal * e.cfg.BorrowRate * now.Sub(l.Since).Hours()
+		l.Since = now
+	}
+}
+
+// RepayLoan pays back amount of the asset loan from the margin wallet,
+// interest first.
+func (e *Exchange) RepayLoan(asset string, amount float64) (string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.settle()
+	l, ok := e.loans[asset]
+	if !ok {
+		return "", fmt.Errorf("%s: no %s loan", e.name, asset)
+	}
+	e.accrue(l, e.clock.Now())
+	amount = math.Min(amount, l.Principal+l.Interest)
+	if e.wallets[exchange.MARGIN][asset] < amount {
+		return "", fmt.Errorf("%s: insufficient MARGIN %s balance to repay %f", e.name, asset, amount)
+	}
+	e.wallets[exchange.MARGIN][asset] -= amount
+	paid := math.Min(amount, l.Interest)
+	l.Interest -= paid
+	l.Principal -= amount - paid
+	if l.Principal+l.Interest <= 0 {
+		delete(e.loans, asset)
+	}
+	id := e.id()
+	e.save()
+	return id, nil
+}
+
+func (e *Exchange) Balances() ([]balance.AccountBalance, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.settle()
+	var balances []balance.AccountBalance
+	for wallet, assets := range e.wallets {
+		for asset, amount := range assets {
+			if amount != 0 {
+				balances = append(balances, balance.AccountBalance{Currency: asset, Balance: amount, Wallet: wallet})
+			}
+		}
+	}
+	sort.Slice(balances, func(i, j int) bool {
+		if balances[i].Wallet != balances[j].Wallet {
+			return balances[i].Wallet < balances[j].Wallet
+		}
+		return balances[i].Currency < balances[j].Currency
+	})
+	return balances, nil
+}
+
+func (e *Exchange) Transfer(asset, from, to string, amount float64) (string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.settle()
+	if e.wallets[from] == nil || e.wallets[to] == nil {
+		return "", fmt.Errorf("%s: unsupported transfer from %s to %s", e.name, from, to)
+	}
+	if e.wallets[from][asset] < amount {
+		return "", fmt.Errorf("%s: insufficient %s %s balance: %f < %f", e.name, from, asset, e.wallets[from][asset], amount)
+	}
+	e.wallets[from][asset] -= amount
+	e.wallets[to][asset] += amount
+	id := e.id()
+	e.save()
+	return id, nil
+}
+
+// Networks reports one chain that every simulated exchange supports, free
+// of fees.
+func (e *Exchange) Networks(asset string) ([]transfer.Network, error) {
+	return []transfer.Network{{Name: "SIM", Chain: "SIM", DepositEnabled: true, WithdrawEnabled: true}}, nil
+}
+
+// DepositAddress names this exchange, so that a withdrawal from another
+// simulated exchange finds it in the exchange registry.
+func (e *Exchange) DepositAddress(asset, network string) (transfer.DepositAdress, error) {
+	return transfer.DepositAdress{Exchange: e.name, Adress: "sim:" + e.name, Chain: network}, nil
+}
+
+// Withdraw sends amount from the spot wallet to the funding wallet of the
+// simulated exchange behind address, where it arrives after TransferDelay.
+func (e *Exchange) Withdraw(asset string, amount float64, address transfer.DepositAdress) (string, error) {
+	name, ok := strings.CutPrefix(address.Adress, "sim:")
+	if !ok {
+		return "", fmt.Errorf("%s: %s is not a simulated deposit address", e.name, address.Adress)
+	}
+	ex, err := exchange.Get(name)
+	if err != nil {
+		return "", err
+	}
+	receiver, ok := ex.(*Exchange)
+	if !ok {
+		return "", fmt.Errorf("%s: %s is not simulated", e.name, name)
+	}
+
+	e.mu.Lock()
+	e.settle()
+	if e.wallets[exchange.SPOT][asset] < amount {
+		e.mu.Unlock()
+		return "", fmt.Errorf("%s: insufficient SPOT %s balance: %f < %f", e.name, asset, e.wallets[exchange.SPOT][asset], amount)
+	}
+	e.wallets[exchange.SPOT][asset] -= amount
+	id := e.id()
+	e.save()
+	at := e.clock.Now().Add(e.cfg.TransferDelay)
+	e.mu.Unlock()
+
+	receiver.mu.Lock()
+	receiver.pending = append(receiver.pending, arrival{Asset: asset, Amount: amount, At: at, TxId: id})
+	receiver.save()
+	receiver.mu.Unlock()
+	return id, nil
+}
+
+// Withdrawal reports the withdrawals of this exchange as sent right away;
+// they are in transit until they arrive at the receiver.
+func (e *Exchange) Withdrawal(asset, id string) (transfer.Withdrawal, error) {
+	if !strings.HasPrefix(id, strings.ToLower(e.name)+"-") {
+		return transfer.Withdrawal{}, fmt.Errorf("%s: withdrawal %s not found", e.name, id)
+	}
+	return transfer.Withdrawal{Id: id, Asset: asset, TxId: id, Status: transfer.TransferCompleted}, nil
+}
+
+// DepositByTx finds a withdrawal from another simulated exchange by its ID,
+// pending until TransferDelay passed.
+func (e *Exchange) DepositByTx(asset, txId string) (transfer.Deposit, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.settle()
+	for _, a := range e.pending {
+		if a.TxId == txId && a.Asset == asset {
+			return transfer.Deposit{Asset: asset, Amount: a.Amount, TxId: txId, Status: transfer.TransferPending}, nil
+		}
+	}
+	for _, a := range e.arrived {
+		if a.TxId == txId && a.Asset == asset {
+			return transfer.Deposit{Asset: asset, Amount: a.Amount, TxId: txId, Status: transfer.TransferCompleted}, nil
+		}
+	}
+	return transfer.Deposit{}, transfer.ErrDepositNotFound
+}
+
+// settle credits the withdrawals that have arrived by now.
+func (e *Exchange) settle() {
+	now := e.clock.Now()
+	pending := e.pending[:0]
+	for _, a := range e.pending {
+		if a.At.After(now) {
+			pending = append(pending, a)
+			continue
+		}
+		e.wallets[exchange.FUNDING][a.Asset] += a.Amount
+		e.arrived = append(e.arrived, a)
+	}
+	if len(pending) < len(e.pending) {
+		e.pending = pending
+		e.save()
+	}
+}
+
+func (e *Exchange) id() string {
+	e.nextId++
+	return fmt.Sprintf("%s-%d", strings.ToLower(e.name), e.nextId)
+}
+
+// Fills returns every order executed so far.
+func (e *Exchange) Fills() []Fill {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]Fill(nil), e.fills...)
+}
+
+// Value marks the exchange to market in the quote asset. equity counts every
+// wallet, withdrawals in flight and loans with their interest; deployed is
+// the absolute value of the net base asset positions.
+func (e *Exchange) Value() (equity, deployed float64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := e.clock.Now()
+	e.settle()
+
+	net := make(map[string]float64) // Asset -> Holdings minus debt
+	for _, assets := range e.wallets {
+		for asset, amount := range assets {
+			net[asset] += amount
+		}
+	}
+	for _, a := range e.pending {
+		net[a.Asset] += a.Amount
+	}
+	for asset, l := range e.loans {
+		e.accrue(l, now)
+		net[asset] -= l.Principal + l.Interest
+	}
+
+	for asset, amount := range net {
+		if asset == quoteAsset {
+			equity += amount
+			continue
+		}
+		if q, ok := e.quotes.Quote(asset+"-"+quoteAsset, now); ok && q.Bid > 0 && q.Ask > 0 {
+			e.marks[asset] = (q.Bid + q.Ask) / 2
+		}
+		equity += amount * e.marks[asset]
+		deployed += math.Abs(amount * e.marks[asset])
+	}
+	return equity, deployed
+}
@@ -0,0 +1,82 @@
+package sim
+
+import (
+	"arbitrage/exchange"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Timeline holds a whole recording in memory, so that an order can fill
+// against the quote that was in effect once its latency has passed.
+type Timeline struct {
+	markets map[string]*market
+}
+
+type market struct {
+	quotes map[string][]Quote // InstId -> Quotes in time order
+	stale  []time.Time        // Disconnects in time order
+}
+
+func NewTimeline() *Timeline {
+	return &Timeline{markets: make(map[string]*market)}
+}
+
+// Add appends a quote. Quotes must be added in receive order; invalid ones
+// are skipped.
+func (t *Timeline) Add(ticker exchange.TickerGeneral) {
+	m, ok := t.markets[ticker.Market]
+	if !ok {
+		m = &market{quotes: make(map[string][]Quote)}
+		t.markets[ticker.Market] = m
+	}
+	at := time.UnixMicro(ticker.Received)
+	if ticker.Stale {
+		m.stale = append(m.stale, at)
+		return
+	}
+
+	q := Quote{Time: at}
+	fields := []struct {
+		value string
+		dest  *float64
+	}{
+		{ticker.BidPrice, &q.Bid},
+		{ticker.BidSize, &q.BidSize},
+		{ticker.AskPrice, &q.Ask},
+		{ticker.AskSize, &q.AskSize},
+	}
+	for _, f := range fields {
+		v, err := strconv.ParseFloat(f.value, 64)
+		if err != nil {
+			return
+		}
+		*f.dest = v
+	}
+	m.quotes[ticker.InstId] = append(m.quotes[ticker.InstId], q)
+}
+
+// Market returns the quotes of one exchange.
+func (t *Timeline) Market(name string) Quotes {
+	m, ok := t.markets[name]
+	if !ok {
+		m = &market{quotes: make(map[string][]Quote)}
+		t.markets[name] = m
+	}
+	return m
+}
+
+// Quote returns the latest quote of instId received at or before at, unless
+// the exchange disconnected since.
+func (m *market) Quote(instId string, at time.Time) (Quote, bool) {
+	quotes := m.quotes[instId]
+	i := sort.Search(len(quotes), func(i int) bool { return quotes[i].Time.After(at) }) - 1
+	if i < 0 {
+		return Quote{}, false
+	}
+	j := sort.Search(len(m.stale), func(j int) bool { return m.stale[j].After(at) }) - 1
+	if j >= 0 && m.stale[j].After(quotes[i].Time) {
+		return Quote{}, false
+	}
+	return quotes[i], true
+}
@@ -0,0 +1,322 @@
+package transfer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Funds are only withdrawn to addresses an operator approved beforehand.
+// The address book holds the deposit address, and memo where the chain
+// needs one, of every (exchange, coin, chain) funds may be sent to, and the
+// per-withdrawal and daily limits of every coin. A withdrawal whose address
+// or memo differs from the approved one, that exceeds a limit or the
+// sending exchange's WithdrawMin/WithdrawMax, or for which there is no entry
+// at all, is refused before it reaches the exchange.
+
+// ErrWithdrawalRefused wraps the reason a withdrawal was refused.
+var ErrWithdrawalRefused = errors.New("withdrawal refused")
+
+// memoChains are networks, as CanonicalNetwork names them, on which
+// exchanges credit deposits by memo. The exchanges' NeedsMemo covers the
+// networks missing here.
+var memoChains = map[string]bool{
+	"XRP":  true,
+	"XLM":  true,
+	"EOS":  true,
+	"TON":  true,
+	"HBAR": true,
+	"ATOM": true,
+	"BNB":  true,
+	"KAVA": true,
+	"IOST": true,
+	"STX":  true,
+	"XEM":  true,
+}
+
+// ApprovedAddress is the deposit address of Coin on Chain at Exchange that
+// funds may be withdrawn to.
+type ApprovedAddress struct {
+	Exchange  string    `json:"exchange"`
+	Coin      string    `json:"coin"`
+	Chain     string    `json:"chain"` // As CanonicalNetwork names it
+	Address   string    `json:"address"`
+	Memo      string    `json:"memo,omitempty"`
+	NeedsMemo bool      `json:"needsMemo"`
+	Approved  time.Time `json:"approved"`
+}
+
+// Limit caps the withdrawals of Coin, in the coin.
+type Limit struct {
+	Coin          string  `json:"coin"`
+	PerWithdrawal float64 `json:"perWithdrawal"`
+	Daily         float64 `json:"daily"` // Across exchanges, per UTC day
+}
+
+const (
+	addressPrefix   = "addressbook/"
+	limitPrefix     = "withdrawlimit/"
+	withdrawnPrefix = "withdrawn/" // withdrawn/<day>/<coin>: amount withdrawn that day
+)
+
+var (
+	bookMu sync.Mutex
+	book   *leveldb.DB
+)
+
+// UseAddressBook keeps the address book in db. Without it every withdrawal
+// is refused.
+func UseAddressBook(db *leveldb.DB) {
+	bookMu.Lock()
+	defer bookMu.Unlock()
+	book = db
+}
+
+func addressKey(exchange, coin, chain string) []byte {
+	return []byte(addressPrefix + exchange + "/" + coin + "/" + chain)
+}
+
+// Approve adds a to the address book, replacing the address approved for
+// the same exchange, coin and chain. A memo chain must be given a memo.
+func Approve(a ApprovedAddress) error {
+	a.Exchange = strings.ToUpper(a.Exchange)
+	a.Coin = strings.ToUpper(a.Coin)
+	a.Chain = CanonicalNetwork(a.Chain)
+	a.NeedsMemo = a.Memo != "" || memoChains[a.Chain]
+	if a.Exchange == "" || a.Coin == "" || a.Chain == "" || a.Address == "" {
+		return errors.New("exchange, coin, chain and address are required")
+	}
+	if a.NeedsMemo && a.Memo == "" {
+		return fmt.Errorf("%s deposits are credited by memo, one is required", a.Chain)
+	}
+	a.Approved = time.Now().UTC()
+
+	data, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	bookMu.Lock()
+	defer bookMu.Unlock()
+	if book == nil {
+		return errors.New("no address book")
+	}
+	return book.Put(addressKey(a.Exchange, a.Coin, a.Chain), data, &opt.WriteOptions{Sync: true})
+}
+
+// Revoke removes the address approved for exchange, coin and chain.
+func Revoke(exchange, coin, chain string) error {
+	bookMu.Lock()
+	defer bookMu.Unlock()
+	if book == nil {
+		return errors.New("no address book")
+	}
+	key := addressKey(strings.ToUpper(exchange), strings.ToUpper(coin), CanonicalNetwork(chain))
+	if ok, err := book.Has(key, nil); err != nil || !ok {
+		return fmt.Errorf("no approved %s %s address on %s", coin, chain, exchange)
+	}
+	return book.Delete(key, &opt.WriteOptions{Sync: true})
+}
+
+// SetLimit sets the withdrawal limits of l.Coin.
+func SetLimit(l Limit) error {
+	l.Coin = strings.ToUpper(l.Coin)
+	if l.Coin == "" || l.PerWithdrawal <= 0 || l.Daily <= 0 {
+		return errors.New("coin and positive limits are required")
+	}
+	data, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	bookMu.Lock()
+	defer bookMu.Unlock()
+	if book == nil {
+		return errors.New("no address book")
+	}
+	return book.Put([]byte(limitPrefix+l.Coin), data, &opt.WriteOptions{Sync: true})
+}
+
+// Addresses returns the approved addresses.
+func Addresses() ([]ApprovedAddress, error) {
+	var addresses []ApprovedAddress
+	err := list(addressPrefix, func(value []byte) error {
+		var a ApprovedAddress
+		if err := json.Unmarshal(value, &a); err != nil {
+			return err
+		}
+		addresses = append(addresses, a)
+		return nil
+	})
+	return addresses, err
+}
+
+// Limits returns the withdrawal limits of every coin that has them.
+func Limits() ([]Limit, error) {
+	var limits []Limit
+	err := list(limitPrefix, func(value []byte) error {
+		var l Limit
+		if err := json.Unmarshal(value, &l); err != nil {
+			return err
+		}
+		limits = append(limits, l)
+		return nil
+	})
+	return limits, err
+}
+
+// Withdrawn returns the amount of coin withdrawn on the UTC day of t.
+func Withdrawn(coin string, t time.Time) (float64, error) {
+	bookMu.Lock()
+	defer bookMu.Unlock()
+	if book == nil {
+		return 0, errors.New("no address book")
+	}
+	return withdrawn(coin, t)
+}
+
+func list(prefix string, add func(value []byte) error) error {
+	bookMu.Lock()
+	defer bookMu.Unlock()
+	if book == nil {
+		return errors.New("no address book")
+	}
+	iter := book.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+	defer iter.Release()
+	for iter.Next() {
+		if err := add(iter.Value()); err != nil {
+			log.Printf("address book: %s: %v", iter.Key(), err)
+		}
+	}
+	return iter.Error()
+}
+
+func withdrawnKey(coin string, t time.Time) []byte {
+	return []byte(withdrawnPrefix + t.UTC().Format("2006-01-02") + "/" + coin)
+}
+
+// withdrawn must be called with bookMu held.
+func withdrawn(coin string, t time.Time) (float64, error) {
+	value, err := book.Get(withdrawnKey(coin, t), nil)
+	if err == leveldb.ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(string(value), 64)
+}
+
+// setWithdrawn must be called with bookMu held.
+func setWithdrawn(coin string, t time.Time, amount float64) error {
+	return book.Put(withdrawnKey(coin, t), []byte(strconv.FormatFloat(amount, 'f', -1, 64)), &opt.WriteOptions{Sync: true})
+}
+
+// screen refuses address unless it is the one approved for asset on its
+// exchange and chain, memo included. Without an address book it passes every
+// address, as only the withdrawal itself must be refused then.
+func screen(asset string, address DepositAdress) error {
+	bookMu.Lock()
+	defer bookMu.Unlock()
+	if book == nil {
+		return nil
+	}
+	return approved(asset, address)
+}
+
+// approved must be called with bookMu held.
+func approved(asset string, address DepositAdress) error {
+	if book == nil {
+		return fmt.Errorf("%w: no address book", ErrWithdrawalRefused)
+	}
+	chain := CanonicalNetwork(address.Chain)
+	value, err := book.Get(addressKey(address.Exchange, asset, chain), nil)
+	if err == leveldb.ErrNotFound {
+		return fmt.Errorf("%w: no approved %s address on %s at %s", ErrWithdrawalRefused, asset, chain, address.Exchange)
+	}
+	if err != nil {
+		return err
+	}
+	var a ApprovedAddress
+	if err := json.Unmarshal(value, &a); err != nil {
+		return err
+	}
+	switch {
+	case address.Adress != a.Address:
+		return fmt.Errorf("%w: %s address %s on %s at %s is not the approved %s", ErrWithdrawalRefused, asset, address.Adress, chain, a.Exchange, a.Address)
+	case address.Memo != a.Memo:
+		return fmt.Errorf("%w: %s memo %q on %s at %s is not the approved %q", ErrWithdrawalRefused, asset, address.Memo, chain, a.Exchange, a.Memo)
+	}
+	return nil
+}
+
+// reserveWithdrawal checks a withdrawal of amount of asset to address over
+// sending, the sending exchange's network, and counts it towards the day's
+// limit. The returned release takes it off again, for a withdrawal the
+// exchange rejected.
+func reserveWithdrawal(asset string, amount float64, address DepositAdress, sending Network) (release func(), err error) {
+	bookMu.Lock()
+	defer bookMu.Unlock()
+	if err := approved(asset, address); err != nil {
+		return nil, err
+	}
+	if (sending.NeedsMemo || memoChains[CanonicalNetwork(address.Chain)]) && address.Memo == "" {
+		return nil, fmt.Errorf("%w: %s on %s needs a memo", ErrWithdrawalRefused, asset, address.Chain)
+	}
+
+	switch {
+	case !(amount > 0) || math.IsInf(amount, 0):
+		return nil, fmt.Errorf("%w: invalid amount %v %s", ErrWithdrawalRefused, amount, asset)
+	case amount < sending.WithdrawMin:
+		return nil, fmt.Errorf("%w: %v %s is below the minimum of %v on %s", ErrWithdrawalRefused, amount, asset, sending.WithdrawMin, address.Chain)
+	case sending.WithdrawMax > 0 && amount > sending.WithdrawMax:
+		return nil, fmt.Errorf("%w: %v %s is above the maximum of %v on %s", ErrWithdrawalRefused, amount, asset, sending.WithdrawMax, address.Chain)
+	}
+
+	value, err := book.Get([]byte(limitPrefix+asset), nil)
+	if err == leveldb.ErrNotFound {
+		return nil, fmt.Errorf("%w: no withdrawal limits set for %s", ErrWithdrawalRefused, asset)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var limit Limit
+	if err := json.Unmarshal(value, &limit); err != nil {
+		return nil, err
+	}
+	if amount > limit.PerWithdrawal {
+		return nil, fmt.Errorf("%w: %v %s is above the limit of %v per withdrawal", ErrWithdrawalRefused, amount, asset, limit.PerWithdrawal)
+	}
+
+	now := time.Now()
+	today, err := withdrawn(asset, now)
+	if err != nil {
+		return nil, err
+	}
+	if today+amount > limit.Daily {
+		return nil, fmt.Errorf("%w: %v %s would take today's withdrawals to %v, above the daily limit of %v", ErrWithdrawalRefused, amount, asset, today+amount, limit.Daily)
+	}
+	if err := setWithdrawn(asset, now, today+amount); err != nil {
+		return nil, err
+	}
+
+	return func() {
+		bookMu.Lock()
+		defer bookMu.Unlock()
+		today, err := withdrawn(asset, now)
+		if err == nil {
+			err = setWithdrawn(asset, now, math.Max(today-amount, 0))
+		}
+		if err != nil {
+			log.Printf("address book: releasing %v %s: %v", amount, asset, err)
+		}
+	}, nil
+}
@@ -0,0 +1,132 @@
+package transfer
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+// testBook keeps the address book in memory for the test, with approved
+// KuCoin addresses of USDT on TRON, XRP on the XRP Ledger and, without
+// limits, DOGE.
+func testBook(t *testing.T) {
+	t.Helper()
+	db, err := leveldb.Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	UseAddressBook(db)
+	t.Cleanup(func() {
+		UseAddressBook(nil)
+		db.Close()
+	})
+
+	for _, a := range []ApprovedAddress{
+		{Exchange: "kucoin", Coin: "usdt", Chain: "TRC20", Address: "TUSDT"},
+		{Exchange: "KUCOIN", Coin: "XRP", Chain: "XRP", Address: "rXRP", Memo: "42"},
+		{Exchange: "KUCOIN", Coin: "DOGE", Chain: "DOGE", Address: "DDOGE"},
+	} {
+		if err := Approve(a); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, l := range []Limit{
+		{Coin: "USDT", PerWithdrawal: 100, Daily: 150},
+		{Coin: "XRP", PerWithdrawal: 1000, Daily: 1000},
+	} {
+		if err := SetLimit(l); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+var (
+	usdtAddress = DepositAdress{Exchange: "KUCOIN", Adress: "TUSDT", Chain: "TRX"}
+	tron        = Network{Name: "TRX", WithdrawMin: 10, WithdrawMax: 500}
+)
+
+func TestReserveWithdrawalRefuses(t *testing.T) {
+	testBook(t)
+	xrp := DepositAdress{Exchange: "KUCOIN", Adress: "rXRP", Chain: "XRP", Memo: "42"}
+
+	tests := []struct {
+		name    string
+		asset   string
+		amount  float64
+		address DepositAdress
+		sending Network
+	}{
+		{"address not approved", "USDT", 50, DepositAdress{Exchange: "KUCOIN", Adress: "TOTHER", Chain: "TRX"}, tron},
+		{"chain not approved", "USDT", 50, DepositAdress{Exchange: "KUCOIN", Adress: "TUSDT", Chain: "BSC"}, tron},
+		{"exchange not approved", "USDT", 50, DepositAdress{Exchange: "BINANCE", Adress: "TUSDT", Chain: "TRX"}, tron},
+		{"memo differs", "XRP", 50, DepositAdress{Exchange: "KUCOIN", Adress: "rXRP", Chain: "XRP", Memo: "43"}, Network{Name: "XRP"}},
+		{"zero amount", "USDT", 0, usdtAddress, tron},
+		{"below the network minimum", "USDT", 5, usdtAddress, tron},
+		{"above the network maximum", "XRP", 600, xrp, Network{Name: "XRP", WithdrawMax: 500}},
+		{"above the per withdrawal limit", "USDT", 101, usdtAddress, tron},
+		{"no limits for the coin", "DOGE", 50, DepositAdress{Exchange: "KUCOIN", Adress: "DDOGE", Chain: "DOGE"}, Network{Name: "DOGE"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := reserveWithdrawal(tt.asset, tt.amount, tt.address, tt.sending); !errors.Is(err, ErrWithdrawalRefused) {
+				t.Errorf("reserveWithdrawal = %v, want it refused", err)
+			}
+		})
+	}
+	if today, _ := Withdrawn("USDT", time.Now()); today != 0 {
+		t.Errorf("refused withdrawals counted: %v USDT withdrawn today", today)
+	}
+}
+
+func TestReserveWithdrawalDailyLimit(t *testing.T) {
+	testBook(t)
+
+	// Each step reserves amount, or releases the reservation of an earlier step
+	steps := []struct {
+		name    string
+		amount  float64
+		release int // Index of the step to release, -1 for none
+		refused bool
+		today   float64
+	}{
+		{"first withdrawal", 80, -1, false, 80},
+		{"second within the daily limit", 60, -1, false, 140},
+		{"third above the daily limit", 20, -1, true, 140},
+		{"exactly up to the daily limit", 10, -1, false, 150},
+		{"rejected second is released", 0, 1, false, 90},
+		{"room again after the release", 50, -1, false, 140},
+	}
+
+	releases := make([]func(), len(steps))
+	for i, s := range steps {
+		if s.release >= 0 {
+			releases[s.release]()
+		} else {
+			release, err := reserveWithdrawal("USDT", s.amount, usdtAddress, tron)
+			if refused := errors.Is(err, ErrWithdrawalRefused); refused != s.refused || (err != nil && !refused) {
+				t.Fatalf("%s: reserveWithdrawal = %v, want refused %v", s.name, err, s.refused)
+			}
+			releases[i] = release
+		}
+		today, err := Withdrawn("USDT", time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if today != s.today {
+			t.Errorf("%s: %v USDT withdrawn today, want %v", s.name, today, s.today)
+		}
+	}
+
+	if other, _ := Withdrawn("USDT", time.Now().AddDate(0, 0, -1)); other != 0 {
+		t.Errorf("%v USDT withdrawn yesterday, want the counter kept per day", other)
+	}
+}
+
+func TestReserveWithdrawalWithoutBook(t *testing.T) {
+	if _, err := reserveWithdrawal("USDT", 50, usdtAddress, tron); !errors.Is(err, ErrWithdrawalRefused) {
+		t.Errorf("reserveWithdrawal without an address book = %v, want it refused", err)
+	}
+}
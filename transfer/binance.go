@@ -1,21 +1,16 @@
 package transfer
 
 import (
-	"errors"
-	"sort"
+	"arbitrage/rest"
     "bytes"
 	"fmt"
-	"log"
 	"net/http"
 	"strconv"
-	"time"
+	"strings"
     "io"
 	"math"
     "encoding/json"
 	"net/url"
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/hex"
 
 )
 
@@ -32,12 +27,6 @@ func BinanceFunding2Spot(asset string, amount float64) (string, error){
 	return binanceInternal(asset, "FUNDING_MAIN", amount)
 }
 
-func GenerateSignature(queryString, secret string) string {
-	h := hmac.New(sha256.New, []byte(secret))
-	h.Write([]byte(queryString))
-	return hex.EncodeToString(h.Sum(nil))
-}
-
 type TransferResponse struct {
 	TranID int64 `json:"tranId"`
 }
@@ -46,16 +35,13 @@ func binanceInternal(asset, transferType string, amount float64) (string, error)
 	endpoint := "https://api.binance.com/sapi/v1/asset/transfer"
 
 	// Set parameters
-	timestamp := strconv.FormatInt(time.Now().UnixMilli(), 10)
 	params := url.Values{}
 	params.Set("type", transferType) // Transfer from Margin (cross) to Spot
 	params.Set("asset", asset)
 	params.Set("amount", strconv.FormatFloat(amount, 'f', -1, 64))
-	params.Set("timestamp", timestamp)
 
-	// Generate signature
-	signature := GenerateSignature(params.Encode(), binanceAPISecret)
-	params.Set("signature", signature)
+	// Sign the request
+	rest.SignBinance(params)
 
 	// Create the request
 	req, err := http.NewRequest("POST", endpoint, bytes.NewBufferString(params.Encode()))
@@ -64,12 +50,11 @@ func binanceInternal(asset, transferType string, amount float64) (string, error)
 	}
 
 	// Set headers
-	req.Header.Set("X-MBX-APIKEY", binanceAPIKey)
+	req.Header.Set("X-MBX-APIKEY", binanceKey())
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
 	// Make the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := rest.Binance.Do(req)
 	if err != nil {
 		return "", err
 	}
@@ -82,8 +67,8 @@ func binanceInternal(asset, transferType string, amount float64) (string, error)
 	}
 
 	// Handle errors from Binance API
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("binance transfer API request failed: %s", string(body))
+	if err := rest.BinanceError(resp, body); err != nil {
+		return "", fmt.Errorf("binance transfer: %w", err)
 	}
 
 	// Parse the response
@@ -95,60 +80,92 @@ func binanceInternal(asset, transferType string, amount float64) (string, error)
 	return strconv.FormatInt(transferResponse.TranID, 10), nil
 }
 
-func wround(stamount string, multiple float64) string {
-	log.Println(multiple)
-	amount, _ := strconv.ParseFloat(stamount, 64)
-	roun := math.Floor(math.Floor(amount/multiple) * multiple)
-	log.Println(roun)
-	return strconv.FormatFloat(roun, 'f', -1, 64)
+// wround rounds amount down to a whole number of multiple, Binance's
+// withdrawIntegerMultiple, written with as many decimals as multiple has.
+func wround(amount, multiple string) (string, error) {
+	step, err := strconv.ParseFloat(multiple, 64)
+	if err != nil || !(step > 0) {
+		return "", fmt.Errorf("invalid withdrawal multiple %q", multiple)
+	}
+	decimals := 0
+	if i := strings.IndexByte(multiple, '.'); i >= 0 {
+		decimals = len(strings.TrimRight(multiple[i+1:], "0"))
+	}
+	value, err := strconv.ParseFloat(amount, 64)
+	if err != nil {
+		return "", err
+	}
+	// The epsilon keeps an amount that is already a multiple from losing a step to float error
+	return strconv.FormatFloat(math.Floor(value/step+1e-9)*step, 'f', decimals, 64), nil
 }
 
-func withdrawFunds(asset, amount, address, memo, network string) (string, error) {
+func withdrawFunds(asset, amount string, address DepositAdress) (string, error) {
 	endpoint := "https://api.binance.com/sapi/v1/capital/withdraw/apply"
 
-	coins, _ := getAllCoins()
-	var multiple float64
+	coins, err := getAllCoins()
+	if err != nil {
+		return "", err
+	}
+	var multiple string
+	var sending *Network
 
 	for _, coin := range coins {
 		for _, chain := range coin.NetworkList {
-			if chain.Network == network {
-				multiple, _ = strconv.ParseFloat(chain.WithdrawIntegerMultiple, 64)
+			if coin.Coin == asset && chain.Network == address.Chain {
+				multiple = chain.WithdrawIntegerMultiple
+				sending = &Network{Name: chain.Network, Chain: chain.Network, NeedsMemo: chain.SameAddress}
+				sending.WithdrawMin, _ = strconv.ParseFloat(chain.WithdrawMin, 64)
+				sending.WithdrawMax, _ = strconv.ParseFloat(chain.WithdrawMax, 64)
 			}
 		}
 	}
+	if sending == nil {
+		return "", fmt.Errorf("%w: binance has no %s network for %s", ErrWithdrawalRefused, address.Chain, asset)
+	}
+
+	// Check the amount actually sent against the address book and limits
+	rounded, err := wround(amount, multiple)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s on %s: %v", ErrWithdrawalRefused, asset, address.Chain, err)
+	}
+	sent, _ := strconv.ParseFloat(rounded, 64)
+	release, err := reserveWithdrawal(asset, sent, address, *sending)
+	if err != nil {
+		return "", err
+	}
 
 	// Set parameters
-	timestamp := strconv.FormatInt(time.Now().UnixMilli(), 10)
 	params := url.Values{}
 	params.Set("coin", asset)
-	params.Set("address", address)
-	params.Set("amount", wround(amount, multiple))
-	params.Set("network", network)
-	params.Set("timestamp", timestamp)
+	params.Set("address", address.Adress)
+	params.Set("amount", rounded)
+	params.Set("network", address.Chain)
 
 	// Optional fields
-	if memo != "" {
-		params.Set("addressTag", memo) // Memo or AddressTag for some networks
+	if address.Memo != "" {
+		params.Set("addressTag", address.Memo) // Memo or AddressTag for some networks
 	}
 
-	// Generate signature
-	signature := GenerateSignature(params.Encode(), binanceAPISecret)
-	params.Set("signature", signature)
+	// Sign the request
+	rest.SignBinance(params)
 
 	// Create the request
 	req, err := http.NewRequest("POST", endpoint, bytes.NewBufferString(params.Encode()))
 	if err != nil {
+		release()
 		return "", err
 	}
 
 	// Set headers
-	req.Header.Set("X-MBX-APIKEY", binanceAPIKey)
+	req.Header.Set("X-MBX-APIKEY", binanceKey())
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
 	// Make the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := rest.Binance.Do(req)
 	if err != nil {
+		if rest.Rejected(err) {
+			release()
+		}
 		return "", err
 	}
 	defer resp.Body.Close()
@@ -160,8 +177,11 @@ func withdrawFunds(asset, amount, address, memo, network string) (string, error)
 	}
 
 	// Handle errors from Binance API
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("binance withdraw API request failed: %s", string(body))
+	if err := rest.BinanceError(resp, body); err != nil {
+		if rest.Rejected(err) {
+			release()
+		}
+		return "", fmt.Errorf("binance withdraw: %w", err)
 	}
 
 	// Parse the response
@@ -233,13 +253,10 @@ func GetAllMarginAllowed() ([]string, error) {
 	endpoint := "https://api.binance.com/sapi/v1/margin/allPairs"
 
 	// Set parameters
-	timestamp := strconv.FormatInt(time.Now().UnixMilli(), 10)
 	params := url.Values{}
-	params.Set("timestamp", timestamp)
 
-	// Generate signature
-	signature := GenerateSignature(params.Encode(), binanceAPISecret)
-	params.Set("signature", signature)
+	// Sign the request
+	rest.SignBinance(params)
 
 	// Create the request
 	req, err := http.NewRequest("GET", endpoint+"?"+params.Encode(), nil)
@@ -248,11 +265,10 @@ func GetAllMarginAllowed() ([]string, error) {
 	}
 
 	// Set headers
-	req.Header.Set("X-MBX-APIKEY", binanceAPIKey)
+	req.Header.Set("X-MBX-APIKEY", binanceKey())
 
 	// Make the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := rest.Binance.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -265,8 +281,8 @@ func GetAllMarginAllowed() ([]string, error) {
 	}
 
 	// Handle errors from Binance API
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("binance margin API request failed: %s", string(body))
+	if err := rest.BinanceError(resp, body); err != nil {
+		return nil, fmt.Errorf("binance margin: %w", err)
 	}
 
 	// Parse the response
@@ -290,13 +306,10 @@ func getAllCoins() ([]CoinInfo, error) {
 	endpoint := "https://api.binance.com/sapi/v1/capital/config/getall"
 
 	// Set parameters
-	timestamp := strconv.FormatInt(time.Now().UnixMilli(), 10)
 	params := url.Values{}
-	params.Set("timestamp", timestamp)
 
-	// Generate signature
-	signature := GenerateSignature(params.Encode(), binanceAPISecret)
-	params.Set("signature", signature)
+	// Sign the request
+	rest.SignBinance(params)
 
 	// Create the request
 	req, err := http.NewRequest("GET", endpoint+"?"+params.Encode(), nil)
@@ -305,11 +318,10 @@ func getAllCoins() ([]CoinInfo, error) {
 	}
 
 	// Set headers
-	req.Header.Set("X-MBX-APIKEY", binanceAPIKey)
+	req.Header.Set("X-MBX-APIKEY", binanceKey())
 
 	// Make the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := rest.Binance.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -322,8 +334,8 @@ func getAllCoins() ([]CoinInfo, error) {
 	}
 
 	// Handle errors from Binance API
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("binance capital config API request failed: %s", string(body))
+	if err := rest.BinanceError(resp, body); err != nil {
+		return nil, fmt.Errorf("binance capital config: %w", err)
 	}
 
 	// Parse the response
@@ -337,36 +349,48 @@ func getAllCoins() ([]CoinInfo, error) {
 
 func Binance2Kucoin(asset string, amount float64) (string, error) {
 
-	// Step 1: Get the deposit address from KuCoin
-	kucoinAddress, err := KucoinGetDepositAddress(asset)
+	// Step 1: Get the KuCoin deposit address on a network both sides support
+	kucoinAddress, err := Route(asset, BinanceNetworks, KucoinNetworks, KucoinGetDepositAddress)
 	if err != nil {
-		log.Fatalf("Error getting KuCoin deposit address: %v", err)
+		return "", fmt.Errorf("kucoin deposit address for %s: %w", asset, err)
+	}
+	if kucoinAddress.Adress == "" {
+		return "", fmt.Errorf("no kucoin deposit address for %s", asset)
 	}
 
 	// Step 2: Transfer funds from Binance to the KuCoin deposit address
-	withdrawalID, err := withdrawFunds(asset, strconv.FormatFloat(amount, 'f', -1, 64), kucoinAddress.Adress, kucoinAddress.Memo, kucoinAddress.Chain)
+	withdrawalID, err := BinanceWithdraw(asset, amount, kucoinAddress)
 	if err != nil {
-		log.Fatalf("Error withdrawing funds from Binance: %v", err)
-        return "", err
+		return "", fmt.Errorf("binance withdraw %s: %w", asset, err)
 	}
 
-	fmt.Printf("Funds transferred successfully. Withdrawal ID: %s\n", withdrawalID)
-    return withdrawalID, nil
+	return withdrawalID, nil
+}
+
+// BinanceDepositAddressOn retrieves the Binance deposit address of coin on network.
+func BinanceDepositAddressOn(coin, network string) (DepositAdress, error) {
+	res, err := getDepositAddress(coin, network)
+	if err != nil {
+		return DepositAdress{}, err
+	}
+	return DepositAdress{Exchange: "BINANCE", Adress: res.Address, Memo: res.Tag, Chain: network}, nil
+}
+
+// BinanceWithdraw sends funds from Binance to an external deposit address.
+func BinanceWithdraw(asset string, amount float64, address DepositAdress) (string, error) {
+	return withdrawFunds(asset, strconv.FormatFloat(amount, 'f', -1, 64), address)
 }
 
 func getDepositAddress(coin, network string) (*DepositAddressResponse, error) {
 	endpoint := "https://api.binance.com/sapi/v1/capital/deposit/address"
 
 	// Set parameters
-	timestamp := strconv.FormatInt(time.Now().UnixMilli(), 10)
 	params := url.Values{}
 	params.Set("coin", coin)
 	params.Set("network", network)
-	params.Set("timestamp", timestamp)
 
-	// Generate signature
-	signature := GenerateSignature(params.Encode(), binanceAPISecret)
-	params.Set("signature", signature)
+	// Sign the request
+	rest.SignBinance(params)
 
 	// Create the request
 	req, err := http.NewRequest("GET", endpoint+"?"+params.Encode(), nil)
@@ -375,11 +399,10 @@ func getDepositAddress(coin, network string) (*DepositAddressResponse, error) {
 	}
 
 	// Set headers
-	req.Header.Set("X-MBX-APIKEY", binanceAPIKey)
+	req.Header.Set("X-MBX-APIKEY", binanceKey())
 
 	// Make the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := rest.Binance.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -392,8 +415,8 @@ func getDepositAddress(coin, network string) (*DepositAddressResponse, error) {
 	}
 
 	// Handle errors from Binance API
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("binance capital deposit address API request failed: %s", string(body))
+	if err := rest.BinanceError(resp, body); err != nil {
+		return nil, fmt.Errorf("binance capital deposit address: %w", err)
 	}
 
 	// Parse the response
@@ -412,92 +435,18 @@ type DepositAddressResponse struct {
 	URL      string `json:"url"`
 }
 
-func BinanceDepositAdress(coin, failedNetwork string) (DepositAdress, error){
-
-    coins, err := getAllCoins()
-    if err != nil {
-        return DepositAdress{}, err
-    }
-
-    var chain string
-    
-    for _, c := range coins{
-        if c.Coin == coin {
-            type FeeInfo struct {
-                Network string
-                Fee      float64
-            }
-            fees := []FeeInfo{}
-            for _, network := range c.NetworkList {
-                if network.WithdrawEnable{
-                    fee, err := strconv.ParseFloat(network.WithdrawFee, 64)
-                    if err != nil {
-                        fmt.Println("error converting string to float64:", err)
-                        continue
-                    }
-                    fees = append(fees, FeeInfo{Network: network.Network, Fee: fee})
-                }
-            }
-            sort.Slice(fees, func(i, j int) bool {
-                return fees[i].Fee < fees[j].Fee
-            })
-            findNetworkIndex := func(Network string) int {
-                for i, feeInfo := range fees {
-                    if feeInfo.Network == Network {
-                        return i
-                    }
-                }
-                return -1 // Return -1 if the Network is not found
-            }
-            index := findNetworkIndex(failedNetwork)
-            if index >= len(fees)-1 {
-                err := errors.New("no network found")
-                return DepositAdress{}, err
-            }
-            if index == -1 {
-                chain = fees[0].Network
-            } else {
-                chain = fees[index+1].Network
-            }
-        }
-    }
-
-    if chain == ""{
-        err := errors.New("no network found")
-        return DepositAdress{}, err
-    }
-
-    res, err := getDepositAddress(coin, chain)
-
-    if err != nil {
-        return DepositAdress{}, err
-    }
-
-    adress := DepositAdress{
-        Adress: res.Address,
-        Memo: res.Tag,
-        Chain: chain,
-    }
-
-    return adress, nil
-}
-
-
 func BinanceRepayMarginLoan(asset string, amount float64) (string, error) {
 	endpoint := "https://api.binance.com/sapi/v1/margin/borrow-repay"
 
 	// Set parameters
-	timestamp := strconv.FormatInt(time.Now().UnixMilli(), 10)
 	params := url.Values{}
 	params.Set("asset", asset)
 	params.Set("isIsolated", "FALSE")
 	params.Set("amount", strconv.FormatFloat(amount, 'f', -1, 64))
 	params.Set("type", "REPAY")
-	params.Set("timestamp", timestamp)
 
-	// Generate signature
-	signature := GenerateSignature(params.Encode(), binanceAPISecret)
-	params.Set("signature", signature)
+	// Sign the request
+	rest.SignBinance(params)
 
 	// Create the request
 	req, err := http.NewRequest("POST", endpoint+"?"+params.Encode(), nil)
@@ -506,11 +455,10 @@ func BinanceRepayMarginLoan(asset string, amount float64) (string, error) {
 	}
 
 	// Set headers
-	req.Header.Set("X-MBX-APIKEY", binanceAPIKey)
+	req.Header.Set("X-MBX-APIKEY", binanceKey())
 
 	// Make the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := rest.Binance.Do(req)
 	if err != nil {
 		return "", err
 	}
@@ -523,19 +471,19 @@ func BinanceRepayMarginLoan(asset string, amount float64) (string, error) {
 	}
 
 	// Handle errors from Binance API
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("binance repay margin loan API request failed: %s", string(body))
+	if err := rest.BinanceError(resp, body); err != nil {
+		return "", fmt.Errorf("binance repay margin loan: %w", err)
 	}
 
 	// Parse the response
 	type TransactionResponse struct {
-		TranID string `json:"tranId"`
+		TranID int64 `json:"tranId"`
 	}
 	var transaction TransactionResponse
 	if err := json.Unmarshal(body, &transaction); err != nil {
 		return "", err
 	}
 
-	return transaction.TranID, nil
+	return strconv.FormatInt(transaction.TranID, 10), nil
 }
 
@@ -0,0 +1,26 @@
+package transfer
+
+import "testing"
+
+func TestWround(t *testing.T) {
+	tests := []struct {
+		amount, multiple string
+		want             string
+		err              bool
+	}{
+		{"123.456789", "0.01", "123.45", false},
+		{"123.456789", "1", "123", false},
+		{"0.3", "0.1", "0.3", false}, // Not 0.2 from float error
+		{"1234.5", "10", "1230", false},
+		{"5.123456789", "0.00000001", "5.12345678", false},
+		{"0.005", "0.01", "0.00", false},
+		{"10", "0", "", true},
+		{"10", "", "", true},
+	}
+	for _, tt := range tests {
+		got, err := wround(tt.amount, tt.multiple)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("wround(%q, %q) = %q, %v, want %q, error %v", tt.amount, tt.multiple, got, err, tt.want, tt.err)
+		}
+	}
+}
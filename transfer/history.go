@@ -0,0 +1,273 @@
+package transfer
+
+import (
+	"arbitrage/rest"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// A withdrawal is followed by the ID the sending exchange returned for it,
+// until it reports the transaction hash; the deposit is then found on the
+// receiving exchange by that hash. A balance showing up is not enough, as it
+// may come from an unrelated deposit or have been there already.
+
+// Withdrawal and deposit statuses, normalized across the exchanges.
+const (
+	TransferPending   = "PENDING"
+	TransferCompleted = "COMPLETED" // Withdrawal sent on chain, or deposit credited
+	TransferFailed    = "FAILED"
+)
+
+// ErrDepositNotFound is returned while no deposit of a transaction is known.
+var ErrDepositNotFound = errors.New("deposit not found")
+
+// Withdrawal is a withdrawal as the sending exchange reports it.
+type Withdrawal struct {
+	Id      string
+	Asset   string
+	Amount  float64
+	Fee     float64
+	Address string
+	Chain   string
+	TxId    string // Empty until the transaction is broadcast
+	Status  string
+}
+
+// Deposit is a deposit as the receiving exchange reports it.
+type Deposit struct {
+	Asset   string
+	Amount  float64
+	Address string
+	Chain   string
+	TxId    string
+	Status  string // TransferCompleted once the funds are credited
+}
+
+// BinanceWithdrawal looks up a withdrawal of asset by the ID withdrawFunds returned.
+func BinanceWithdrawal(asset, id string) (Withdrawal, error) {
+	params := url.Values{}
+	params.Set("coin", asset)
+	params.Set("idList", id)
+
+	var history []struct {
+		Id             string `json:"id"`
+		Amount         string `json:"amount"`
+		TransactionFee string `json:"transactionFee"`
+		Coin           string `json:"coin"`
+		Status         int    `json:"status"`
+		Address        string `json:"address"`
+		TxId           string `json:"txId"`
+		Network        string `json:"network"`
+	}
+	if err := binanceHistory("/sapi/v1/capital/withdraw/history", params, &history); err != nil {
+		return Withdrawal{}, fmt.Errorf("binance withdrawal %s: %w", id, err)
+	}
+	for _, h := range history {
+		if h.Id != id {
+			continue
+		}
+		w := Withdrawal{Id: h.Id, Asset: h.Coin, Address: h.Address, Chain: h.Network, TxId: h.TxId, Status: TransferPending}
+		w.Amount, _ = strconv.ParseFloat(h.Amount, 64)
+		w.Fee, _ = strconv.ParseFloat(h.TransactionFee, 64)
+		switch h.Status {
+		case 6: // Completed
+			w.Status = TransferCompleted
+		case 1, 3, 5: // Cancelled, rejected, failed
+			w.Status = TransferFailed
+		}
+		return w, nil
+	}
+	return Withdrawal{}, fmt.Errorf("binance withdrawal %s not found", id)
+}
+
+// BinanceDeposit looks up the deposit of asset made by transaction txId.
+func BinanceDeposit(asset, txId string) (Deposit, error) {
+	params := url.Values{}
+	params.Set("coin", asset)
+	params.Set("txId", txId)
+
+	var history []struct {
+		Amount  string `json:"amount"`
+		Coin    string `json:"coin"`
+		Network string `json:"network"`
+		Status  int    `json:"status"`
+		Address string `json:"address"`
+		TxId    string `json:"txId"`
+	}
+	if err := binanceHistory("/sapi/v1/capital/deposit/hisrec", params, &history); err != nil {
+		return Deposit{}, fmt.Errorf("binance deposit %s: %w", txId, err)
+	}
+	for _, h := range history {
+		if h.TxId != txId {
+			continue
+		}
+		d := Deposit{Asset: h.Coin, Address: h.Address, Chain: h.Network, TxId: h.TxId, Status: TransferPending}
+		d.Amount, _ = strconv.ParseFloat(h.Amount, 64)
+		switch h.Status {
+		case 1, 6: // Success, credited but not yet withdrawable
+			d.Status = TransferCompleted
+		case 7: // Wrong deposit
+			d.Status = TransferFailed
+		}
+		return d, nil
+	}
+	return Deposit{}, ErrDepositNotFound
+}
+
+// binanceHistory sends a signed GET to a capital history endpoint and
+// decodes the response into v.
+func binanceHistory(endpoint string, params url.Values, v interface{}) error {
+	// Sign the request
+	rest.SignBinance(params)
+
+	req, err := http.NewRequest("GET", rest.BinanceURL+endpoint+"?"+params.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-MBX-APIKEY", binanceKey())
+
+	resp, err := rest.Binance.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if err := rest.BinanceError(resp, body); err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
+
+// KucoinWithdrawal looks up a withdrawal of asset by the ID KucoinWithdraw returned.
+func KucoinWithdrawal(asset, id string) (Withdrawal, error) {
+	var found *Withdrawal
+	err := kucoinHistory("/api/v1/withdrawals", asset, func(items json.RawMessage) (bool, error) {
+		var page []struct {
+			Id         string `json:"id"`
+			Currency   string `json:"currency"`
+			Chain      string `json:"chain"`
+			Address    string `json:"address"`
+			Amount     string `json:"amount"`
+			Fee        string `json:"fee"`
+			WalletTxId string `json:"walletTxId"`
+			Status     string `json:"status"`
+		}
+		if err := json.Unmarshal(items, &page); err != nil {
+			return false, err
+		}
+		for _, h := range page {
+			if h.Id != id {
+				continue
+			}
+			w := Withdrawal{Id: h.Id, Asset: h.Currency, Address: h.Address, Chain: h.Chain, TxId: h.WalletTxId, Status: TransferPending}
+			w.Amount, _ = strconv.ParseFloat(h.Amount, 64)
+			w.Fee, _ = strconv.ParseFloat(h.Fee, 64)
+			switch h.Status {
+			case "SUCCESS":
+				w.Status = TransferCompleted
+			case "FAILURE":
+				w.Status = TransferFailed
+			}
+			found = &w
+			return true, nil
+		}
+		return false, nil
+	})
+	if err != nil {
+		return Withdrawal{}, fmt.Errorf("kucoin withdrawal %s: %w", id, err)
+	}
+	if found == nil {
+		return Withdrawal{}, fmt.Errorf("kucoin withdrawal %s not found", id)
+	}
+	return *found, nil
+}
+
+// KucoinDeposit looks up the deposit of asset made by transaction txId.
+func KucoinDeposit(asset, txId string) (Deposit, error) {
+	var found *Deposit
+	err := kucoinHistory("/api/v1/deposits", asset, func(items json.RawMessage) (bool, error) {
+		var page []struct {
+			Currency   string `json:"currency"`
+			Chain      string `json:"chain"`
+			Address    string `json:"address"`
+			Amount     string `json:"amount"`
+			WalletTxId string `json:"walletTxId"`
+			Status     string `json:"status"`
+		}
+		if err := json.Unmarshal(items, &page); err != nil {
+			return false, err
+		}
+		for _, h := range page {
+			// KuCoin appends the output index to the hash, as in hash@0
+			if h.WalletTxId != txId && !strings.HasPrefix(h.WalletTxId, txId+"@") {
+				continue
+			}
+			d := Deposit{Asset: h.Currency, Address: h.Address, Chain: h.Chain, TxId: txId, Status: TransferPending}
+			d.Amount, _ = strconv.ParseFloat(h.Amount, 64)
+			switch h.Status {
+			case "SUCCESS":
+				d.Status = TransferCompleted
+			case "FAILURE":
+				d.Status = TransferFailed
+			}
+			found = &d
+			return true, nil
+		}
+		return false, nil
+	})
+	if err != nil {
+		return Deposit{}, fmt.Errorf("kucoin deposit %s: %w", txId, err)
+	}
+	if found == nil {
+		return Deposit{}, ErrDepositNotFound
+	}
+	return *found, nil
+}
+
+// kucoinHistory pages through a KuCoin history endpoint for currency,
+// newest first, handing the items of each page to match until it reports
+// a match.
+func kucoinHistory(endpoint, currency string, match func(items json.RawMessage) (bool, error)) error {
+	query := url.Values{"currency": {currency}, "pageSize": {"100"}}
+	for page := 1; ; page++ {
+		query.Set("currentPage", strconv.Itoa(page))
+		path := endpoint + "?" + query.Encode()
+		req, err := http.NewRequest("GET", baseURL+path, nil)
+		if err != nil {
+			return err
+		}
+		rest.SignKucoin(req, path, "")
+
+		resp, err := rest.Kucoin.Do(req)
+		if err != nil {
+			return err
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		var list struct {
+			TotalPage int             `json:"totalPage"`
+			Items     json.RawMessage `json:"items"`
+		}
+		if err := rest.DecodeKucoin(resp, body, &list); err != nil {
+			return err
+		}
+		found, err := match(list.Items)
+		if err != nil || found || page >= list.TotalPage {
+			return err
+		}
+	}
+}
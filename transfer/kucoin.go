@@ -1,17 +1,13 @@
 package transfer
 
 import (
+	"arbitrage/rest"
 	"bytes"
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"strconv"
-	"time"
 
 	"github.com/google/uuid"
 )
@@ -21,30 +17,36 @@ var (
 	baseURL = "https://api.kucoin.com"
 )
 
-// GetDepositAddress retrieves the deposit address for a given cryptocurrency on KuCoin.
-func KucoinGetDepositAddress(currency string) (DepositAdress, error) {
-	// Create the request
-	endpoint := "/api/v3/deposit-addresses"
+type kucoinDepositAddress struct {
+	Address   string `json:"address"`
+	Memo      string `json:"memo"`
+	ChainId   string `json:"chainId"`
+	ChainName string `json:"chainName"`
+}
 
-	url := fmt.Sprintf("%s%s?currency=%s", baseURL, endpoint, currency)
-	req, err := http.NewRequest("GET", url, nil)
+// KucoinGetDepositAddress retrieves the deposit address of currency on
+// KuCoin on the network named network, as CanonicalNetwork names it,
+// creating the address when there is none yet.
+func KucoinGetDepositAddress(currency, network string) (DepositAdress, error) {
+	chain, err := kucoinChain(currency, network)
+	if err != nil {
+		return DepositAdress{}, err
+	}
+
+	// Create the request
+	endpoint := fmt.Sprintf("/api/v3/deposit-addresses?currency=%s&chain=%s", currency, chain)
+	req, err := http.NewRequest("GET", baseURL+endpoint, nil)
 	if err != nil {
 		return DepositAdress{}, err
 	}
 
 	// Add headers
-	timestamp := strconv.FormatInt(time.Now().UnixMilli(), 10)
-	signature := signRequest(kucoinAPISecret, timestamp, "GET", fmt.Sprintf("%s?currency=%s", endpoint, currency), "")
-	req.Header.Set("KC-API-KEY", kucoinAPIKey)
-	req.Header.Set("KC-API-SIGN", signature)
-	req.Header.Set("KC-API-TIMESTAMP", timestamp)
-	req.Header.Set("KC-API-PASSPHRASE", signPassphrase(kucoinPassphrase))
+	rest.SignKucoin(req, endpoint, "")
 	req.Header.Set("KC-API-KEY-VERSION", "3")
 	req.Header.Set("Content-Type", "application/json")
 
 	// Execute the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := rest.Kucoin.Do(req)
 	if err != nil {
 		return DepositAdress{}, err
 	}
@@ -56,52 +58,37 @@ func KucoinGetDepositAddress(currency string) (DepositAdress, error) {
 		return DepositAdress{}, err
 	}
 
-	var result map[string]interface{}
-	if err := json.Unmarshal(body, &result); err != nil {
+	var data []kucoinDepositAddress
+	if err := rest.DecodeKucoin(resp, body, &data); err != nil {
 		return DepositAdress{}, err
 	}
-
-	// Check for errors in the response
-	if resp.StatusCode != http.StatusOK || result["code"] != "200000" {
-		return DepositAdress{}, fmt.Errorf("error response: %s", string(body))
+	for _, a := range data {
+		if a.ChainId == chain {
+			return DepositAdress{Exchange: "KUCOIN", Adress: a.Address, Memo: a.Memo, Chain: network}, nil
+		}
 	}
+	return kucoinCreateDepositAdress(currency, chain, network)
+}
 
-	data, ok := result["data"].([]interface{})
-	if !ok {
-		return DepositAdress{}, nil
+func kucoinCreateDepositAdress(currency, chain, network string) (DepositAdress, error) {
+	payload, err := json.Marshal(map[string]string{"currency": currency, "chain": chain})
+	if err != nil {
+		return DepositAdress{}, err
 	}
 
-	adress := DepositAdress{
-		Adress: data[0].(map[string]interface{})["address"].(string),
-		Memo:   data[0].(map[string]interface{})["memo"].(string),
-		Chain:  data[0].(map[string]interface{})["chainName"].(string),
-	}
-	return adress, nil
-}
-
-func kucoinCreateDepositAdress(currency string) (DepositAdress, error) {
 	// Create the request
-	endpoint := "/api/v1/deposit-addresses"
-
-	url := fmt.Sprintf("%s%s", baseURL, endpoint)
-	req, err := http.NewRequest("POST", url, nil)
+	endpoint := "/api/v3/deposit-address/create"
+	req, err := http.NewRequest("POST", baseURL+endpoint, bytes.NewBuffer(payload))
 	if err != nil {
 		return DepositAdress{}, err
 	}
 
 	// Add headers
-	timestamp := strconv.FormatInt(time.Now().UnixMilli(), 10)
-	signature := signRequest(kucoinAPISecret, timestamp, "POST", endpoint, "")
-	req.Header.Set("KC-API-KEY", kucoinAPIKey)
-	req.Header.Set("KC-API-SIGN", signature)
-	req.Header.Set("KC-API-TIMESTAMP", timestamp)
-	req.Header.Set("KC-API-PASSPHRASE", signPassphrase(kucoinPassphrase))
-	req.Header.Set("KC-API-KEY-VERSION", "2")
+	rest.SignKucoin(req, endpoint, string(payload))
 	req.Header.Set("Content-Type", "application/json")
 
 	// Execute the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := rest.Kucoin.Do(req)
 	if err != nil {
 		return DepositAdress{}, err
 	}
@@ -113,27 +100,11 @@ func kucoinCreateDepositAdress(currency string) (DepositAdress, error) {
 		return DepositAdress{}, err
 	}
 
-	var result map[string]interface{}
-	if err := json.Unmarshal(body, &result); err != nil {
+	var data kucoinDepositAddress
+	if err := rest.DecodeKucoin(resp, body, &data); err != nil {
 		return DepositAdress{}, err
 	}
-
-	// Check for errors in the response
-	if resp.StatusCode != http.StatusOK || result["code"] != "200000" {
-		return DepositAdress{}, fmt.Errorf("error response: %s", string(body))
-	}
-
-	data, ok := result["data"].([]interface{})
-	if !ok {
-		return DepositAdress{}, nil
-	}
-
-	adress := DepositAdress{
-		Adress: data[0].(map[string]interface{})["address"].(string),
-		Memo:   data[0].(map[string]interface{})["memo"].(string),
-		Chain:  data[0].(map[string]interface{})["chainName"].(string),
-	}
-	return adress, nil
+	return DepositAdress{Exchange: "KUCOIN", Adress: data.Address, Memo: data.Memo, Chain: network}, nil
 }
 
 // TransferSpotToMargin transfers funds from the spot wallet to the margin wallet on KuCoin.
@@ -173,18 +144,11 @@ func kucoinInternalTransfer(currency, from, to string, amount float64) (string,
 	}
 
 	// Add headers
-	timestamp := strconv.FormatInt(time.Now().UnixMilli(), 10)
-	signature := signRequest(kucoinAPISecret, timestamp, "POST", endpoint, string(jsonBody))
-	req.Header.Set("KC-API-KEY", kucoinAPIKey)
-	req.Header.Set("KC-API-SIGN", signature)
-	req.Header.Set("KC-API-TIMESTAMP", timestamp)
-	req.Header.Set("KC-API-PASSPHRASE", signPassphrase(kucoinPassphrase))
-	req.Header.Set("KC-API-KEY-VERSION", "2")
+	rest.SignKucoin(req, endpoint, string(jsonBody))
 	req.Header.Set("Content-Type", "application/json")
 
 	// Execute the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := rest.Kucoin.Do(req)
 	if err != nil {
 		return "", err
 	}
@@ -196,45 +160,54 @@ func kucoinInternalTransfer(currency, from, to string, amount float64) (string,
 		return "", err
 	}
 
-	var result map[string]interface{}
-	if err := json.Unmarshal(body, &result); err != nil {
-		return "", err
+	var data struct {
+		OrderId string `json:"orderId"`
 	}
-
-	// Check for errors in the response
-	if resp.StatusCode != http.StatusOK || result["code"] != "200000" {
-		return "", fmt.Errorf("error response: %s", string(body))
+	if err := rest.DecodeKucoin(resp, body, &data); err != nil {
+		return "", err
 	}
-
 	// Return the transfer ID
-	data, ok := result["data"].(map[string]interface{})
-	if !ok {
-		log.Println(result)
-		return "", nil
-	}
-	return data["orderId"].(string), nil
+	return data.OrderId, nil
 }
 
 func TransferFromKucoinToBinance(currency string, amount float64) (string, error) {
 	// Get Binance deposit address
-	binanceAdress, err := BinanceDepositAdress(currency, "")
+	binanceAdress, err := Route(currency, KucoinNetworks, BinanceNetworks, BinanceDepositAddressOn)
 	if err != nil {
 		return "", fmt.Errorf("failed to get Binance deposit address: %v", err)
 	}
 
+	return KucoinWithdraw(currency, amount, binanceAdress)
+}
+
+// KucoinWithdraw sends funds from the KuCoin main account to an external deposit address.
+func KucoinWithdraw(currency string, amount float64, address DepositAdress) (string, error) {
+	sending, err := kucoinNetwork(currency, address.Chain)
+	if err != nil {
+		return "", err
+	}
+
+	// Check the withdrawal against the address book and limits
+	release, err := reserveWithdrawal(currency, amount, address, sending)
+	if err != nil {
+		return "", err
+	}
+
 	// Prepare the request payload for the KuCoin withdrawal
 	withdrawal := map[string]interface{}{
+		"chain":     sending.Chain,
 		"currency":  currency,
 		"amount":    strconv.FormatFloat(amount, 'f', -1, 64),
-		"address":   binanceAdress.Adress,
-		"memo":      binanceAdress.Memo,
+		"address":   address.Adress,
+		"memo":      address.Memo,
 		"isInner":   false,
 		"clientOid": uuid.New().String(),
-		"remark":    "Transfer to Binance",
+		"remark":    "Arbitrage transfer",
 	}
 
 	jsonBody, err := json.Marshal(withdrawal)
 	if err != nil {
+		release()
 		return "", fmt.Errorf("failed to marshal request body: %v", err)
 	}
 
@@ -243,23 +216,20 @@ func TransferFromKucoinToBinance(currency string, amount float64) (string, error
 	url := fmt.Sprintf("%s%s", baseURL, endpoint)
 	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonBody))
 	if err != nil {
+		release()
 		return "", err
 	}
 
 	// Add headers
-	timestamp := strconv.FormatInt(time.Now().UnixMilli(), 10)
-	signature := signRequest(kucoinAPISecret, timestamp, "POST", endpoint, string(jsonBody))
-	req.Header.Set("KC-API-KEY", kucoinAPIKey)
-	req.Header.Set("KC-API-SIGN", signature)
-	req.Header.Set("KC-API-TIMESTAMP", timestamp)
-	req.Header.Set("KC-API-PASSPHRASE", signPassphrase(kucoinPassphrase))
-	req.Header.Set("KC-API-KEY-VERSION", "2")
+	rest.SignKucoin(req, endpoint, string(jsonBody))
 	req.Header.Set("Content-Type", "application/json")
 
 	// Execute the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := rest.Kucoin.Do(req)
 	if err != nil {
+		if rest.Rejected(err) {
+			release()
+		}
 		return "", err
 	}
 	defer resp.Body.Close()
@@ -270,19 +240,17 @@ func TransferFromKucoinToBinance(currency string, amount float64) (string, error
 		return "", err
 	}
 
-	var result map[string]interface{}
-	if err := json.Unmarshal(body, &result); err != nil {
-		return "", err
+	var data struct {
+		WithdrawalId string `json:"withdrawalId"`
 	}
-
-	// Check for errors in the response
-	if resp.StatusCode != http.StatusOK || result["code"] != "200000" {
-		return "", fmt.Errorf("error response: %s", string(body))
+	if err := rest.DecodeKucoin(resp, body, &data); err != nil {
+		if rest.Rejected(err) {
+			release()
+		}
+		return "", err
 	}
-
 	// Return the withdrawal ID
-	data := result["data"].(map[string]interface{})
-	return data["withdrawalId"].(string), nil
+	return data.WithdrawalId, nil
 }
 
 func KucoinRepayLoan(currency string, amount float64) (string, error) {
@@ -307,18 +275,11 @@ func KucoinRepayLoan(currency string, amount float64) (string, error) {
 	}
 
 	// Add headers
-	timestamp := strconv.FormatInt(time.Now().UnixMilli(), 10)
-	signature := signRequest(kucoinAPISecret, timestamp, "POST", endpoint, string(jsonBody))
-	req.Header.Set("KC-API-KEY", kucoinAPIKey)
-	req.Header.Set("KC-API-SIGN", signature)
-	req.Header.Set("KC-API-TIMESTAMP", timestamp)
-	req.Header.Set("KC-API-PASSPHRASE", signPassphrase(kucoinPassphrase))
-	req.Header.Set("KC-API-KEY-VERSION", "2")
+	rest.SignKucoin(req, endpoint, string(jsonBody))
 	req.Header.Set("Content-Type", "application/json")
 
 	// Execute the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := rest.Kucoin.Do(req)
 	if err != nil {
 		return "", err
 	}
@@ -330,33 +291,12 @@ func KucoinRepayLoan(currency string, amount float64) (string, error) {
 		return "", err
 	}
 
-	var result map[string]interface{}
-	if err := json.Unmarshal(body, &result); err != nil {
-		return "", err
+	var data struct {
+		OrderNo string `json:"orderNo"`
 	}
-
-	// Check for errors in the response
-	if resp.StatusCode != http.StatusOK || result["code"] != "200000" {
-		return "", fmt.Errorf("error response: %s", string(body))
+	if err := rest.DecodeKucoin(resp, body, &data); err != nil {
+		return "", err
 	}
-
 	// Return the repayment ID
-	data := result["data"].(map[string]interface{})
-	return data["orderNo"].(string), nil
-}
-
-func signRequest(secret, timestamp, method, endpoint, body string) string {
-	// Prepare the prehash string
-	prehash := timestamp + method + endpoint + body
-
-	// Create the HMAC signature
-	h := hmac.New(sha256.New, []byte(secret))
-	h.Write([]byte(prehash))
-	return base64.StdEncoding.EncodeToString(h.Sum(nil))
-}
-
-func signPassphrase(passphrase string) string {
-	h := hmac.New(sha256.New, []byte(kucoinAPISecret))
-	h.Write([]byte(passphrase))
-	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+	return data.OrderNo, nil
 }
@@ -3,11 +3,13 @@ package transfer
 import (
 	"log"
     "os"
+	"sync"
 
     "github.com/joho/godotenv"
 )
 
 var (
+	credentialsOnce     sync.Once
 	binanceAPIKey       string
 	binanceAPISecret    string
 	kucoinAPIKey        string
@@ -16,12 +18,20 @@ var (
 )
 
 type DepositAdress struct {
-	Adress string
-	Memo   string
-	Chain  string
+	Exchange string // The exchange the address belongs to
+	Adress   string
+	Memo     string
+	Chain    string
 }
 
-func init() {
+// binanceKey returns the Binance API key. The credentials are loaded, from
+// .env when there is one, and checked on first use.
+func binanceKey() string {
+	credentialsOnce.Do(loadCredentials)
+	return binanceAPIKey
+}
+
+func loadCredentials() {
 	// Load .env file
 	err := godotenv.Load()
 	if err != nil {
@@ -38,4 +48,4 @@ func init() {
 	if kucoinAPIKey == "" || kucoinAPISecret == "" || kucoinPassphrase == "" || binanceAPIKey == "" || binanceAPISecret == "" {
 		log.Fatal("Missing required API keys or secrets in environment variables")
 	}
-}
\ No newline at end of file
+}
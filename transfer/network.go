@@ -0,0 +1,248 @@
+package transfer
+
+import (
+	"arbitrage/rest"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The exchanges name the same chain differently: Binance's BSC is KuCoin's
+// BEP20 (chain ID bsc), Binance's TRX is KuCoin's TRC20. Networks are
+// compared by Binance's name, to which KuCoin's chain IDs and names are
+// mapped first.
+
+// Network is one chain an asset can be moved over, as one exchange supports it.
+type Network struct {
+	Name            string // Canonical name, as Binance names the network
+	Chain           string // The exchange's own identifier of the chain
+	DepositEnabled  bool
+	WithdrawEnabled bool
+	WithdrawFee     float64 // In the asset
+	WithdrawMin     float64
+	WithdrawMax     float64 // 0 when unlimited
+	Confirmations   int     // Until a deposit is credited
+	Minutes         int     // Typical time until a withdrawal arrives, 0 when unknown
+	NeedsMemo       bool
+}
+
+// networkAliases maps KuCoin chain IDs and names, lower-cased, that differ
+// from Binance's network names. Others are compared upper-cased as they are.
+var networkAliases = map[string]string{
+	"erc20":         "ETH",
+	"bep20":         "BSC",
+	"trc20":         "TRX",
+	"polygon pos":   "MATIC",
+	"polygon":       "MATIC",
+	"arbitrum one":  "ARBITRUM",
+	"op":            "OPTIMISM",
+	"avax c-chain":  "AVAXC",
+	"avax-c":        "AVAXC",
+	"aptos":         "APT",
+	"btc-segwit":    "SEGWITBTC",
+	"bitcoin":       "BTC",
+	"solana":        "SOL",
+	"hedera":        "HBAR",
+	"stellar":       "XLM",
+	"ripple":        "XRP",
+	"near protocol": "NEAR",
+}
+
+// CanonicalNetwork returns the name networks are compared by.
+func CanonicalNetwork(name string) string {
+	if canonical, ok := networkAliases[strings.ToLower(name)]; ok {
+		return canonical
+	}
+	return strings.ToUpper(name)
+}
+
+// Match returns the networks of sending that allow withdrawals and that
+// receiving allows deposits on, cheapest first and the quicker of equally
+// cheap ones first. The networks returned are those of sending, with the
+// confirmations receiving waits for.
+func Match(sending, receiving []Network) []Network {
+	deposits := make(map[string]Network)
+	for _, n := range receiving {
+		if n.DepositEnabled {
+			deposits[n.Name] = n
+		}
+	}
+
+	var matched []Network
+	for _, n := range sending {
+		d, ok := deposits[n.Name]
+		if !n.WithdrawEnabled || !ok {
+			continue
+		}
+		n.Confirmations = d.Confirmations
+		n.NeedsMemo = n.NeedsMemo || d.NeedsMemo
+		matched = append(matched, n)
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		a, b := matched[i], matched[j]
+		if a.WithdrawFee != b.WithdrawFee {
+			return a.WithdrawFee < b.WithdrawFee
+		}
+		if a.Minutes != 0 && b.Minutes != 0 && a.Minutes != b.Minutes {
+			return a.Minutes < b.Minutes
+		}
+		return a.Confirmations < b.Confirmations
+	})
+	return matched
+}
+
+// Route returns the receiving side's deposit address of asset on the best
+// network Match finds, trying the next one when an address cannot be had or,
+// while an address book is in use, is not the approved one.
+func Route(asset string, sending, receiving func(asset string) ([]Network, error), address func(asset, network string) (DepositAdress, error)) (DepositAdress, error) {
+	from, err := sending(asset)
+	if err != nil {
+		return DepositAdress{}, err
+	}
+	to, err := receiving(asset)
+	if err != nil {
+		return DepositAdress{}, err
+	}
+	networks := Match(from, to)
+	if len(networks) == 0 {
+		return DepositAdress{}, fmt.Errorf("no network to move %s over", asset)
+	}
+	var last error // Why the last network was passed over
+	for _, n := range networks {
+		a, err := address(asset, n.Name)
+		if err == nil && a.Adress == "" {
+			err = fmt.Errorf("empty %s address on %s", asset, n.Name)
+		}
+		if err == nil {
+			err = screen(asset, a)
+		}
+		if err == nil {
+			return a, nil
+		}
+		last = err
+	}
+	return DepositAdress{}, fmt.Errorf("no deposit address for %s on %d networks: %w", asset, len(networks), last)
+}
+
+// BinanceNetworks lists the networks Binance supports for coin.
+func BinanceNetworks(coin string) ([]Network, error) {
+	coins, err := getAllCoins()
+	if err != nil {
+		return nil, err
+	}
+	var networks []Network
+	for _, c := range coins {
+		if c.Coin != coin {
+			continue
+		}
+		for _, n := range c.NetworkList {
+			network := Network{
+				Name:            n.Network,
+				Chain:           n.Network,
+				DepositEnabled:  n.DepositEnable,
+				WithdrawEnabled: n.WithdrawEnable && !n.Busy,
+				Confirmations:   n.MinConfirm,
+				Minutes:         n.EstimatedArrivalTime,
+				NeedsMemo:       n.SameAddress, // Binance sets it for coins deposited to a shared address plus memo
+			}
+			network.WithdrawFee, _ = strconv.ParseFloat(n.WithdrawFee, 64)
+			network.WithdrawMin, _ = strconv.ParseFloat(n.WithdrawMin, 64)
+			network.WithdrawMax, _ = strconv.ParseFloat(n.WithdrawMax, 64)
+			networks = append(networks, network)
+		}
+	}
+	if len(networks) == 0 {
+		return nil, fmt.Errorf("binance: no networks for %s", coin)
+	}
+	return networks, nil
+}
+
+// KucoinNetworks lists the chains KuCoin supports for currency.
+func KucoinNetworks(currency string) ([]Network, error) {
+	req, err := http.NewRequest("GET", baseURL+"/api/v3/currencies/"+currency, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := rest.Kucoin.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var data struct {
+		Chains []struct {
+			ChainName         string `json:"chainName"`
+			ChainId           string `json:"chainId"`
+			WithdrawalMinSize string `json:"withdrawalMinSize"`
+			WithdrawalMinFee  string `json:"withdrawalMinFee"`
+			MaxWithdraw       string `json:"maxWithdraw"`
+			IsWithdrawEnabled bool   `json:"isWithdrawEnabled"`
+			IsDepositEnabled  bool   `json:"isDepositEnabled"`
+			Confirms          int    `json:"confirms"`
+			NeedTag           bool   `json:"needTag"`
+		} `json:"chains"`
+	}
+	if err := rest.DecodeKucoin(resp, body, &data); err != nil {
+		return nil, fmt.Errorf("kucoin currency %s: %w", currency, err)
+	}
+
+	var networks []Network
+	for _, c := range data.Chains {
+		network := Network{
+			Name:            kucoinNetworkName(c.ChainId, c.ChainName),
+			Chain:           c.ChainId,
+			DepositEnabled:  c.IsDepositEnabled,
+			WithdrawEnabled: c.IsWithdrawEnabled,
+			Confirmations:   c.Confirms,
+			NeedsMemo:       c.NeedTag,
+		}
+		network.WithdrawFee, _ = strconv.ParseFloat(c.WithdrawalMinFee, 64)
+		network.WithdrawMin, _ = strconv.ParseFloat(c.WithdrawalMinSize, 64)
+		network.WithdrawMax, _ = strconv.ParseFloat(c.MaxWithdraw, 64)
+		networks = append(networks, network)
+	}
+	if len(networks) == 0 {
+		return nil, fmt.Errorf("kucoin: no chains for %s", currency)
+	}
+	return networks, nil
+}
+
+// kucoinNetworkName returns the canonical name of a KuCoin chain, by its ID
+// or else its name, falling back to the ID upper-cased.
+func kucoinNetworkName(chainId, chainName string) string {
+	if name, ok := networkAliases[strings.ToLower(chainId)]; ok {
+		return name
+	}
+	if name, ok := networkAliases[strings.ToLower(chainName)]; ok {
+		return name
+	}
+	return strings.ToUpper(chainId)
+}
+
+// kucoinChain returns KuCoin's chain ID of the network named network.
+func kucoinChain(currency, network string) (string, error) {
+	n, err := kucoinNetwork(currency, network)
+	return n.Chain, err
+}
+
+// kucoinNetwork returns KuCoin's chain of currency named network.
+func kucoinNetwork(currency, network string) (Network, error) {
+	networks, err := KucoinNetworks(currency)
+	if err != nil {
+		return Network{}, err
+	}
+	for _, n := range networks {
+		if n.Name == network {
+			return n, nil
+		}
+	}
+	return Network{}, fmt.Errorf("kucoin: %s has no %s chain", currency, network)
+}
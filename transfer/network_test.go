@@ -0,0 +1,150 @@
+package transfer
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestCanonicalNetwork(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"ERC20", "ETH"},
+		{"bep20", "BSC"},
+		{"TRC20", "TRX"},
+		{"Polygon POS", "MATIC"},
+		{"AVAX C-Chain", "AVAXC"},
+		{"BTC-Segwit", "SEGWITBTC"},
+		{"BSC", "BSC"},
+		{"sol", "SOL"},
+	}
+	for _, tt := range tests {
+		if got := CanonicalNetwork(tt.in); got != tt.want {
+			t.Errorf("CanonicalNetwork(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestKucoinNetworkName(t *testing.T) {
+	tests := []struct{ chainId, chainName, want string }{
+		{"bsc", "BEP20", "BSC"},           // By name
+		{"trx", "TRC20", "TRX"},           // By name
+		{"eth", "ERC20", "ETH"},           // By name
+		{"matic", "Polygon POS", "MATIC"}, // By name
+		{"erc20", "Ethereum", "ETH"},      // By ID
+		{"sol", "SOL", "SOL"},             // As it is
+		{"kcc", "KCC", "KCC"},             // As it is
+		{"xrp", "Ripple", "XRP"},          // By name
+	}
+	for _, tt := range tests {
+		if got := kucoinNetworkName(tt.chainId, tt.chainName); got != tt.want {
+			t.Errorf("kucoinNetworkName(%q, %q) = %q, want %q", tt.chainId, tt.chainName, got, tt.want)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		name               string
+		sending, receiving []Network
+		want               []string // Names of the networks matched, in order
+	}{
+		{
+			name: "only networks open on both sides",
+			sending: []Network{
+				{Name: "ETH", WithdrawEnabled: true},
+				{Name: "BSC", WithdrawEnabled: false},
+				{Name: "TRX", WithdrawEnabled: true},
+				{Name: "SOL", WithdrawEnabled: true},
+			},
+			receiving: []Network{
+				{Name: "ETH", DepositEnabled: true},
+				{Name: "BSC", DepositEnabled: true},
+				{Name: "TRX", DepositEnabled: false},
+			},
+			want: []string{"ETH"},
+		},
+		{
+			name: "cheapest first, then quickest, then fewest confirmations",
+			sending: []Network{
+				{Name: "ETH", WithdrawEnabled: true, WithdrawFee: 5},
+				{Name: "BSC", WithdrawEnabled: true, WithdrawFee: 1, Minutes: 10},
+				{Name: "TRX", WithdrawEnabled: true, WithdrawFee: 1, Minutes: 2},
+				{Name: "SOL", WithdrawEnabled: true, WithdrawFee: 1},
+			},
+			receiving: []Network{
+				{Name: "ETH", DepositEnabled: true, Confirmations: 12},
+				{Name: "BSC", DepositEnabled: true, Confirmations: 15},
+				{Name: "TRX", DepositEnabled: true, Confirmations: 20},
+				{Name: "SOL", DepositEnabled: true, Confirmations: 1},
+			},
+			want: []string{"SOL", "TRX", "BSC", "ETH"},
+		},
+		{
+			name:      "nothing in common",
+			sending:   []Network{{Name: "ETH", WithdrawEnabled: true}},
+			receiving: []Network{{Name: "BSC", DepositEnabled: true}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, n := range Match(tt.sending, tt.receiving) {
+				got = append(got, n.Name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Match = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchTakesConfirmationsAndMemoFromReceiving(t *testing.T) {
+	matched := Match(
+		[]Network{{Name: "XRP", Chain: "XRP", WithdrawEnabled: true, WithdrawFee: 0.25, Confirmations: 1}},
+		[]Network{{Name: "XRP", Chain: "xrp", DepositEnabled: true, Confirmations: 6, NeedsMemo: true}},
+	)
+	want := []Network{{Name: "XRP", Chain: "XRP", WithdrawEnabled: true, WithdrawFee: 0.25, Confirmations: 6, NeedsMemo: true}}
+	if !reflect.DeepEqual(matched, want) {
+		t.Errorf("Match = %+v, want %+v", matched, want)
+	}
+}
+
+func TestRoute(t *testing.T) {
+	sending := func(string) ([]Network, error) {
+		return []Network{
+			{Name: "TRX", WithdrawEnabled: true, WithdrawFee: 1},
+			{Name: "BSC", WithdrawEnabled: true, WithdrawFee: 2},
+		}, nil
+	}
+	receiving := func(string) ([]Network, error) {
+		return []Network{{Name: "TRX", DepositEnabled: true}, {Name: "BSC", DepositEnabled: true}}, nil
+	}
+
+	var asked []string
+	address := func(asset, network string) (DepositAdress, error) {
+		asked = append(asked, network)
+		if network == "TRX" {
+			return DepositAdress{}, errors.New("deposits suspended")
+		}
+		return DepositAdress{Adress: "0xabc", Chain: network}, nil
+	}
+
+	got, err := Route("USDT", sending, receiving, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Chain != "BSC" || !reflect.DeepEqual(asked, []string{"TRX", "BSC"}) {
+		t.Errorf("Route = %+v after asking %v, want the BSC address after TRX failed", got, asked)
+	}
+
+	empty := func(string, string) (DepositAdress, error) { return DepositAdress{}, nil }
+	_, err = Route("USDT", sending, receiving, empty)
+	if err == nil || err.Error() != "no deposit address for USDT on 2 networks: empty USDT address on BSC" {
+		t.Errorf("Route with empty addresses = %v", err)
+	}
+
+	none := func(string) ([]Network, error) { return nil, nil }
+	if _, err := Route("USDT", sending, none, address); err == nil {
+		t.Error("Route without a common network succeeded")
+	}
+}
@@ -0,0 +1,156 @@
+package universe
+
+import (
+	"arbitrage/rest"
+	"arbitrage/transfer"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+)
+
+type BinanceFilter struct {
+	FilterType  string `json:"filterType"`
+	MinPrice    string `json:"minPrice"`
+	MaxPrice    string `json:"maxPrice"`
+	TickSize    string `json:"tickSize"`
+	MinQty      string `json:"minQty"`
+	MaxQty      string `json:"maxQty"`
+	StepSize    string `json:"stepSize"`
+	MinNotional string `json:"minNotional"`
+}
+
+type BinanceSymbol struct {
+	Symbol     string          `json:"symbol"`
+	Status     string          `json:"status"`
+	BaseAsset  string          `json:"baseAsset"`
+	QuoteAsset string          `json:"quoteAsset"`
+	Filters    []BinanceFilter `json:"filters"`
+}
+
+type KucoinSymbol struct {
+	Symbol          string `json:"symbol"`
+	BaseCurrency    string `json:"baseCurrency"`
+	QuoteCurrency   string `json:"quoteCurrency"`
+	BaseMinSize     string `json:"baseMinSize"`
+	BaseMaxSize     string `json:"baseMaxSize"`
+	BaseIncrement   string `json:"baseIncrement"`
+	QuoteIncrement  string `json:"quoteIncrement"`
+	PriceIncrement  string `json:"priceIncrement"`
+	MinFunds        string `json:"minFunds"`
+	EnableTrading   bool   `json:"enableTrading"`
+	IsMarginEnabled bool   `json:"isMarginEnabled"`
+}
+
+// BinanceSymbols fetches every spot symbol from Binance exchangeInfo.
+func BinanceSymbols() ([]BinanceSymbol, error) {
+	req, err := http.NewRequest("GET", "https://api.binance.com/api/v3/exchangeInfo", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := rest.Binance.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if err := rest.BinanceError(resp, body); err != nil {
+		return nil, fmt.Errorf("binance exchange info: %w", err)
+	}
+
+	var info struct {
+		Symbols []BinanceSymbol `json:"symbols"`
+	}
+	if err := json.Unmarshal(body, &info); err != nil {
+		return nil, err
+	}
+	return info.Symbols, nil
+}
+
+// KucoinSymbols fetches every spot symbol from KuCoin.
+func KucoinSymbols() ([]KucoinSymbol, error) {
+	req, err := http.NewRequest("GET", "https://api.kucoin.com/api/v2/symbols", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := rest.Kucoin.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var symbols []KucoinSymbol
+	if err := rest.DecodeKucoin(resp, body, &symbols); err != nil {
+		return nil, fmt.Errorf("kucoin symbols: %w", err)
+	}
+	return symbols, nil
+}
+
+// Build returns the instIds (e.g. BTC-USDT) quoted in quote that are trading
+// and margin-enabled on both Binance and KuCoin, sorted by name. Assets are
+// matched by base and quote name rather than by symbol string.
+func Build(quote string) ([]string, error) {
+	var binanceSymbols []BinanceSymbol
+	err := rest.Retry(rest.DefaultPolicy, "binance symbols", func() (err error) {
+		binanceSymbols, err = BinanceSymbols()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	var kucoinSymbols []KucoinSymbol
+	err = rest.Retry(rest.DefaultPolicy, "kucoin symbols", func() (err error) {
+		kucoinSymbols, err = KucoinSymbols()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	var marginBases []string
+	err = rest.Retry(rest.DefaultPolicy, "binance margin pairs", func() (err error) {
+		marginBases, err = transfer.GetAllMarginAllowed()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	binanceMargin := make(map[string]bool)
+	for _, base := range marginBases {
+		binanceMargin[base] = true
+	}
+
+	onBinance := make(map[string]bool)
+	for _, s := range binanceSymbols {
+		if s.Status == "TRADING" && s.QuoteAsset == quote && binanceMargin[s.BaseAsset] {
+			onBinance[s.BaseAsset] = true
+		}
+	}
+
+	var instIds []string
+	for _, s := range kucoinSymbols {
+		if s.EnableTrading && s.IsMarginEnabled && s.QuoteCurrency == quote && onBinance[s.BaseCurrency] {
+			instIds = append(instIds, s.BaseCurrency+"-"+s.QuoteCurrency)
+		}
+	}
+	sort.Strings(instIds)
+
+	if len(instIds) == 0 {
+		return nil, fmt.Errorf("no %s symbols tradable on both exchanges", quote)
+	}
+	log.Printf("universe: %d %s symbols", len(instIds), quote)
+	return instIds, nil
+}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+    "encoding/json"
     "log"
     "os"
     "time"
@@ -9,14 +10,18 @@ import (
 
     "github.com/syndtr/goleveldb/leveldb"
     "github.com/syndtr/goleveldb/leveldb/errors"
+    "github.com/syndtr/goleveldb/leveldb/storage"
 )
 
 type OrderData struct {
 	BuyMarket string
 	SellMarket string
-	BuyPrice float64
-	SellPrice float64
-	Amount float64
+	BuyPrice float64 // Average price of the spot buy
+	SellPrice float64 // Average price of the margin short
+	Amount float64 // Quantity bought on BuyMarket
+	SellAmount float64 // Quantity shorted on SellMarket
+	BuyOrderId string
+	SellOrderId string
 	Coin string
 }
 
@@ -31,39 +36,26 @@ func Database(dbPath string) (*leveldb.DB, error){
 	return db, nil
 }
 
-func SaveOrders(db *leveldb.DB, buyMarket, sellMarket, coin string, amount, buyPrice, sellPrice float64) error{
-	// Save data to the database
-	err := db.Put([]byte("buyMarket"), []byte(buyMarket), nil)
-	if err != nil {
-		return err
-	}
-
-	err = db.Put([]byte("coin"), []byte(coin), nil)
-	if err != nil {
-		return err
-	}
-
-	err = db.Put([]byte("minPrice"), []byte(fmt.Sprintf("%f", buyPrice)), nil)
-	if err != nil {
-		return err
-	}
-
-	err = db.Put([]byte("amount"), []byte(fmt.Sprintf("%f", amount)), nil)
-	if err != nil {
-		return err
-	}
-
-	err = db.Put([]byte("sellMarket"), []byte(sellMarket), nil)
-	if err != nil {
-		return err
-	}
-
-	err = db.Put([]byte("maxPrice"), []byte(fmt.Sprintf("%f", sellPrice)), nil)
-	if err != nil {
-		return err
-	}
-
-	return nil
+// SaveOrders stores the open position; an empty OrderData marks it closed.
+func SaveOrders(db *leveldb.DB, data OrderData) error{
+	values := map[string]string{
+		"buyMarket":   data.BuyMarket,
+		"sellMarket":  data.SellMarket,
+		"coin":        data.Coin,
+		"minPrice":    fmt.Sprintf("%f", data.BuyPrice),
+		"maxPrice":    fmt.Sprintf("%f", data.SellPrice),
+		"amount":      fmt.Sprintf("%f", data.Amount),
+		"sellAmount":  fmt.Sprintf("%f", data.SellAmount),
+		"buyOrderId":  data.BuyOrderId,
+		"sellOrderId": data.SellOrderId,
+	}
+
+	// Save data to the database in one write
+	batch := new(leveldb.Batch)
+	for key, value := range values {
+		batch.Put([]byte(key), []byte(value))
+	}
+	return db.Write(batch, nil)
 }
 
 
@@ -142,7 +134,21 @@ func FindOpenOrders(db *leveldb.DB) (OrderData, bool, error){
 	}
 
 	output.Coin = coin
-    
+
+	// Positions saved before the actual fills were recorded lack these
+	output.SellAmount = output.Amount
+	if res, err = db.Get([]byte("sellAmount"), nil); err == nil {
+		if sellAmount, err := strconv.ParseFloat(string(res), 64); err == nil && sellAmount > 0 {
+			output.SellAmount = sellAmount
+		}
+	}
+	if res, err = db.Get([]byte("buyOrderId"), nil); err == nil {
+		output.BuyOrderId = string(res)
+	}
+	if res, err = db.Get([]byte("sellOrderId"), nil); err == nil {
+		output.SellOrderId = string(res)
+	}
+
 	return output, true, nil
 }
 
@@ -183,3 +189,33 @@ func openLevelDBWithRetry(dbPath string, retries int) (*leveldb.DB, error) {
 
 	return nil, err
 }
+
+// LegOutcome records how a trade whose legs executed differently was resolved.
+type LegOutcome struct {
+	Time       time.Time `json:"time"`
+	Action     string    `json:"action"` // open or close
+	Outcome    string    `json:"outcome"`
+	InstId     string    `json:"instId"`
+	BuyMarket  string    `json:"buyMarket"`
+	SellMarket string    `json:"sellMarket"`
+	BuyQty     float64   `json:"buyQty"`  // Executed on BuyMarket
+	SellQty    float64   `json:"sellQty"` // Executed on SellMarket
+	Unwound    float64   `json:"unwound"` // Traded back to even out the legs
+	Error      string    `json:"error,omitempty"`
+}
+
+// SaveLegOutcome appends outcome to the log under legs/, in time order.
+func SaveLegOutcome(db *leveldb.DB, outcome LegOutcome) error {
+	data, err := json.Marshal(outcome)
+	if err != nil {
+		return err
+	}
+	key := "legs/" + outcome.Time.UTC().Format("20060102T150405.000000000")
+	return db.Put([]byte(key), data, nil)
+}
+
+// MemoryDatabase opens an empty database that lives only in memory, for
+// replays that must not touch the persisted orders.
+func MemoryDatabase() (*leveldb.DB, error) {
+	return leveldb.Open(storage.NewMemStorage(), nil)
+}
@@ -1,57 +1,57 @@
 package utils
 
+import "strings"
+
 type TrieNode struct {
 	children map[rune]*TrieNode
-	isEnd    bool
+	quotes   []string // Of the instIds whose base ends here
 }
 
 func newTrieNode() *TrieNode {
 	return &TrieNode{children: make(map[rune]*TrieNode)}
 }
 
-func (t *TrieNode) insert(word string) {
+func (t *TrieNode) insert(base, quote string) {
 	current := t
-	for _, ch := range word {
+	for _, ch := range base {
 		if _, found := current.children[ch]; !found {
 			current.children[ch] = newTrieNode()
 		}
 		current = current.children[ch]
 	}
-	current.isEnd = true
+	current.quotes = append(current.quotes, quote)
 }
 
-func (t *TrieNode) searchPrefix(prefix string) string {
-	current := t
-	var lastMatch string
-
-	for _, ch := range prefix {
-		if node, found := current.children[ch]; found {
-			current = node
-			lastMatch += string(ch)
-			if current.isEnd {
-				return lastMatch
-			}
-		} else {
+// GetQuote turns a Binance symbol such as ARBUSDT into ARB-USDT, or "" when
+// it is not one of the instIds the trie was built from. Every base on the
+// path is tried because one base can prefix another (AR and ARB).
+func GetQuote(symbol string, trie *TrieNode) string {
+	current := trie
+	for i, ch := range symbol {
+		node, found := current.children[ch]
+		if !found {
 			break
 		}
+		current = node
+		base, rest := symbol[:i+len(string(ch))], symbol[i+len(string(ch)):]
+		for _, quote := range current.quotes {
+			if rest == quote {
+				return base + "-" + quote
+			}
+		}
 	}
 	return ""
 }
 
-func GetQuote(symbol string, trie *TrieNode) string {
-	prefix := trie.searchPrefix(symbol)
-	if prefix == "" || symbol[len(prefix):] != "USDT" {
-		return ""
-	}
-	return prefix + "-" + symbol[len(prefix):]
-}
-
-func Initialize() *TrieNode {
+// Initialize builds the trie used by GetQuote from the universe instIds
+// (e.g. ARB-USDT), so symbols resolve in the quote the universe was built for.
+func Initialize(instIds []string) *TrieNode {
 	trie := newTrieNode()
-	quotes := []string{"XEC", "KAVA", "EDU", "AR", "HNT", "LUNA", "BSW", "BNC", "FLOW", "OP", "LISTA", "NTRN", "NVT", "HARD", "QI", "ZK", "VOXEL", "DYM", "TWT", "MBL", "ATEM", "BURGER", "TFUEL", "XAI", "NFP", "MAGIC", "CKB", "MOVR", "APT", "SUI", "RENDER", "NOT", "GMX", "GNS", "ROSE", "TIA", "EPX", "IO", "USTC", "OSMO", "TAO", "TNSR", "BONK", "SEI", "IOTA", "WIF", "JST", "ETHW", "FLR", "NEAR", "MANTA", "RUNE", "CELO", "SXP", "PYTH", "HBAR", "KLAY", "JTO", "KDA", "EGLD", "GFT", "STRAX", "VELO", "XYM", "NFT", "BOME", "JUP", "SCRT", "POLYX", "XNO", "ALPINE", "BB"}
-
-	for _, quote := range quotes {
-		trie.insert(quote)
+	for _, instId := range instIds {
+		base, quote, found := strings.Cut(instId, "-")
+		if found {
+			trie.insert(base, quote)
+		}
 	}
 	return trie
-}
\ No newline at end of file
+}
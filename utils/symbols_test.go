@@ -0,0 +1,23 @@
+package utils
+
+import "testing"
+
+func TestGetQuote(t *testing.T) {
+	trie := Initialize([]string{"AR-USDT", "ARB-USDT", "BTC-USDC", "ETH-BTC", "ETH-USDT"})
+	tests := []struct{ symbol, want string }{
+		{"ARUSDT", "AR-USDT"},
+		{"ARBUSDT", "ARB-USDT"}, // AR is a prefix of ARB
+		{"BTCUSDC", "BTC-USDC"},
+		{"BTCUSDT", ""}, // Not in the universe in this quote
+		{"ETHBTC", "ETH-BTC"},
+		{"ETHUSDT", "ETH-USDT"},
+		{"ARBUSDC", ""},
+		{"DOGEUSDT", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := GetQuote(tt.symbol, trie); got != tt.want {
+			t.Errorf("GetQuote(%q) = %q, want %q", tt.symbol, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package workflow
+
+import (
+	"arbitrage/events"
+	"arbitrage/exchange"
+	"arbitrage/transfer"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// A rebalance follows a trade that bought base on the spot market of one
+// exchange and shorted it on the margin market of the other. The bought
+// coins are shipped to repay the loan, and the collateral comes back as
+// USDT:
+//
+//	bought:  withdraw base
+//	shorted: await deposit, FUNDING->SPOT->MARGIN, repay, USDT MARGIN->SPOT, withdraw USDT
+//	bought:  await deposit, USDT FUNDING->SPOT
+const rebalanceKind = "rebalance"
+
+// Deposits take as long as the chain needs to confirm them.
+const (
+	transferTimeout = 10 * time.Minute
+	depositTimeout  = 2 * time.Hour
+)
+
+// Rebalance starts a rebalance after amount of base was bought on the
+// exchange named bought and shorted on shorted, with capital USDT of
+// collateral to return, and runs it until ctx is cancelled. It returns the
+// workflow ID.
+func Rebalance(ctx context.Context, bought, shorted, base string, amount, capital float64) (string, error) {
+	return start(ctx, rebalanceKind, map[string]string{
+		"bought":  bought,
+		"shorted": shorted,
+		"base":    base,
+		"amount":  strconv.FormatFloat(amount, 'f', -1, 64),
+		"capital": strconv.FormatFloat(capital, 'f', -1, 64),
+	})
+}
+
+func init() {
+	kinds[rebalanceKind] = []step{
+		{name: "withdraw base", timeout: transferTimeout, once: true, run: withdrawBase},
+		{name: "await base deposit", timeout: depositTimeout, run: awaitDeposit("bought", "shorted", "base", "baseWithdrawal", "arrived"), wake: depositEvent("shorted", "base")},
+		{name: "base FUNDING to SPOT", timeout: transferTimeout, once: true, run: move("shorted", "base", exchange.FUNDING, exchange.SPOT, "arrived")},
+		{name: "base SPOT to MARGIN", timeout: transferTimeout, once: true, run: move("shorted", "base", exchange.SPOT, exchange.MARGIN, "arrived")},
+		{name: "repay loan", timeout: transferTimeout, once: true, run: repay},
+		{name: "USDT MARGIN to SPOT", timeout: transferTimeout, once: true, run: move("shorted", "", exchange.MARGIN, exchange.SPOT, "capital")},
+		{name: "withdraw USDT", timeout: transferTimeout, once: true, run: withdrawUSDT},
+		{name: "await USDT deposit", timeout: depositTimeout, run: awaitDeposit("shorted", "bought", "", "usdtWithdrawal", "returned"), wake: depositEvent("bought", "")},
+		{name: "USDT FUNDING to SPOT", timeout: transferTimeout, once: true, run: move("bought", "", exchange.FUNDING, exchange.SPOT, "returned")},
+	}
+}
+
+// asset returns the asset named by the param key, USDT when key is empty.
+func (w *Workflow) asset(key string) string {
+	if key == "" {
+		return "USDT"
+	}
+	return w.Params[key]
+}
+
+func withdrawBase(w *Workflow) (bool, error) {
+	from, err := exchange.Get(w.Params["bought"])
+	if err != nil {
+		return false, err
+	}
+	to, err := exchange.Get(w.Params["shorted"])
+	if err != nil {
+		return false, err
+	}
+	base := w.Params["base"]
+
+	// Only the coins of this position, less any fee paid in them; others in
+	// the wallet may belong to another position
+	held, err := balanceOf(from, exchange.SPOT, base)
+	if err != nil {
+		return false, err
+	}
+	amount := math.Min(w.float("amount"), held)
+	if amount <= 0 {
+		return false, fmt.Errorf("no %s in %s %s", base, from.Name(), exchange.SPOT)
+	}
+	w.setFloat("amount", amount)
+	address, err := exchange.Route(base, from, to)
+	if err != nil {
+		return false, refused(err)
+	}
+	id, err := from.Withdraw(base, amount, address)
+	if err != nil {
+		return false, settle(refused(err))
+	}
+	w.Params["baseWithdrawal"] = id
+	w.Params["baseNetwork"] = address.Chain
+	return true, nil
+}
+
+func withdrawUSDT(w *Workflow) (bool, error) {
+	from, err := exchange.Get(w.Params["shorted"])
+	if err != nil {
+		return false, err
+	}
+	to, err := exchange.Get(w.Params["bought"])
+	if err != nil {
+		return false, err
+	}
+	address, err := exchange.Route("USDT", from, to)
+	if err != nil {
+		return false, refused(err)
+	}
+	id, err := from.Withdraw("USDT", w.float("capital"), address)
+	if err != nil {
+		return false, settle(refused(err))
+	}
+	w.Params["usdtWithdrawal"] = id
+	w.Params["usdtNetwork"] = address.Chain
+	return true, nil
+}
+
+// refused halts on a withdrawal the address book refused, which retrying
+// does not change until an operator approves the address or a limit.
+func refused(err error) error {
+	if errors.Is(err, transfer.ErrWithdrawalRefused) {
+		return halt(err)
+	}
+	return err
+}
+
+func repay(w *Workflow) (bool, error) {
+	ex, err := exchange.Get(w.Params["shorted"])
+	if err != nil {
+		return false, err
+	}
+	_, err = ex.RepayLoan(w.Params["base"], w.float("arrived"))
+	return err == nil, settle(err)
+}
+
+// awaitDeposit follows the withdrawal recorded under idKey from the exchange
+// named by fromKey until its transaction is credited by the exchange named
+// by toKey, and records the amount credited under amountKey. The transaction
+// hash is recorded under idKey+"Tx" once the sender reports it.
+func awaitDeposit(fromKey, toKey, assetKey, idKey, amountKey string) func(w *Workflow) (bool, error) {
+	return func(w *Workflow) (bool, error) {
+		asset := w.asset(assetKey)
+		txId := w.Params[idKey+"Tx"]
+		if txId == "" {
+			from, err := exchange.Get(w.Params[fromKey])
+			if err != nil {
+				return false, err
+			}
+			withdrawal, err := from.Withdrawal(asset, w.Params[idKey])
+			if err != nil {
+				return false, err
+			}
+			switch {
+			case withdrawal.Status == transfer.TransferFailed:
+				return false, halt(fmt.Errorf("withdrawal %s failed on %s", withdrawal.Id, from.Name()))
+			case withdrawal.TxId == "":
+				return false, nil // Not broadcast yet
+			}
+			txId = withdrawal.TxId
+			w.Params[idKey+"Tx"] = txId
+		}
+
+		to, err := exchange.Get(w.Params[toKey])
+		if err != nil {
+			return false, err
+		}
+		deposit, err := to.DepositByTx(asset, txId)
+		switch {
+		case errors.Is(err, transfer.ErrDepositNotFound):
+			return false, nil
+		case err != nil:
+			return false, err
+		case deposit.Status == transfer.TransferFailed:
+			return false, halt(fmt.Errorf("deposit of %s failed on %s", txId, to.Name()))
+		case deposit.Status != transfer.TransferCompleted:
+			return false, nil
+		}
+		w.setFloat(amountKey, deposit.Amount)
+		return true, nil
+	}
+}
+
+func depositEvent(exKey, assetKey string) func(w *Workflow) func(events.Event) bool {
+	return func(w *Workflow) func(events.Event) bool {
+		return events.BalanceChanged(w.Params[exKey], exchange.FUNDING, w.asset(assetKey))
+	}
+}
+
+// move transfers the amount recorded under amountKey of the asset named by
+// assetKey between two wallets of the exchange named by exKey.
+func move(exKey, assetKey, from, to, amountKey string) func(w *Workflow) (bool, error) {
+	return func(w *Workflow) (bool, error) {
+		ex, err := exchange.Get(w.Params[exKey])
+		if err != nil {
+			return false, err
+		}
+		_, err = ex.Transfer(w.asset(assetKey), from, to, w.float(amountKey))
+		return err == nil, settle(err)
+	}
+}
+
+func balanceOf(ex exchange.Exchange, wallet, asset string) (float64, error) {
+	balances, err := ex.Balances()
+	if err != nil {
+		return 0, err
+	}
+	for _, b := range balances {
+		if b.Wallet == wallet && b.Currency == asset {
+			return b.Balance, nil
+		}
+	}
+	return 0, nil
+}
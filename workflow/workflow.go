@@ -0,0 +1,449 @@
+// Package workflow runs multi-step fund movements, such as shipping an asset
+// to the other exchange to repay a loan there, as state machines persisted in
+// the database. Every step is saved before and after it runs, so that after a
+// restart a workflow resumes at the step it stopped at rather than starting
+// over or being forgotten.
+package workflow
+
+import (
+	"arbitrage/clock"
+	"arbitrage/events"
+	"arbitrage/rest"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Workflow statuses.
+const (
+	Running   = "RUNNING"
+	Done      = "DONE"
+	Escalated = "ESCALATED" // Stopped until an operator retries or skips the step
+)
+
+// Workflow is the persisted state of one run of a kind of workflow.
+type Workflow struct {
+	Id     string            `json:"id"`
+	Kind   string            `json:"kind"`
+	Params map[string]string `json:"params"` // Set at the start and by steps for the steps after them
+	Status string            `json:"status"`
+	Error  string            `json:"error,omitempty"` // Why the workflow was escalated
+
+	Step        int       `json:"step"` // Index of the step to run next
+	StepStarted time.Time `json:"stepStarted"`
+	Attempts    int       `json:"attempts"` // Failed attempts of the step
+	// InFlight is set while a step that moves funds runs. Found set after a
+	// restart, it means the step may or may not have taken effect.
+	InFlight bool `json:"inFlight"`
+
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+}
+
+// step is one action of a workflow. run reports whether the step is
+// complete; a step that is not, such as waiting for a deposit, is run again
+// after the workflow pauses.
+type step struct {
+	name    string
+	timeout time.Duration // From the first attempt, across restarts
+	// once marks steps that move funds and must not take effect twice. One
+	// interrupted by a crash is escalated instead of repeated.
+	once bool
+	run  func(w *Workflow) (bool, error)
+	// wake, if set, ends a pause early on a matching event.
+	wake func(w *Workflow) func(events.Event) bool
+}
+
+// kinds are the step sequences of every kind of workflow.
+var kinds = map[string][]step{}
+
+// StepName returns the name of the step w runs next.
+func (w *Workflow) StepName() string {
+	steps := kinds[w.Kind]
+	if w.Step >= len(steps) {
+		return "done"
+	}
+	return steps[w.Step].name
+}
+
+func (w *Workflow) float(key string) float64 {
+	v, _ := strconv.ParseFloat(w.Params[key], 64)
+	return v
+}
+
+func (w *Workflow) setFloat(key string, v float64) {
+	w.Params[key] = strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+const (
+	prefix      = "workflow/"
+	maxAttempts = 5
+	retryDelay  = 10 * time.Second
+	// pollInterval is how long a step that is not complete waits before it
+	// looks again, unless an event wakes it.
+	pollInterval = 30 * time.Second
+)
+
+var (
+	mu sync.Mutex
+	db *leveldb.DB
+	// running holds the workflows being run by this process.
+	running = make(map[string]bool)
+
+	// clk is the time steps are timed by; Drive swaps in a backtest's.
+	clk clock.Clock = clock.Real{}
+	// driven, once Drive was called, holds the workflows Advance runs.
+	driven map[string]*scheduled
+)
+
+// scheduled is a driven workflow and when it runs next.
+type scheduled struct {
+	w  *Workflow
+	at time.Time
+}
+
+// Drive runs workflows on the time of c, and only when Advance is called,
+// rather than each in a goroutine of its own on the wall clock. A backtest
+// uses it to move simulated funds on recorded time.
+func Drive(c clock.Clock) {
+	mu.Lock()
+	defer mu.Unlock()
+	clk = c
+	driven = make(map[string]*scheduled)
+}
+
+// Advance runs every driven workflow that is due until it waits again, is
+// done or is escalated.
+func Advance(ctx context.Context) {
+	mu.Lock()
+	var due []*scheduled
+	now := clk.Now()
+	for _, s := range driven {
+		if !s.at.After(now) {
+			due = append(due, s)
+		}
+	}
+	mu.Unlock()
+
+	for _, s := range due {
+		for {
+			wait, _, stopped := advance(ctx, s.w)
+			if stopped {
+				mu.Lock()
+				delete(driven, s.w.Id)
+				mu.Unlock()
+				break
+			}
+			if wait > 0 {
+				s.at = now.Add(wait)
+				break
+			}
+		}
+	}
+}
+
+// launch runs w until ctx is cancelled, or leaves it to Advance.
+func launch(ctx context.Context, w *Workflow) {
+	mu.Lock()
+	if driven != nil {
+		driven[w.Id] = &scheduled{w: w, at: clk.Now()}
+		mu.Unlock()
+		return
+	}
+	mu.Unlock()
+	go run(ctx, w)
+}
+
+// Use persists workflows in store.
+func Use(store *leveldb.DB) {
+	mu.Lock()
+	defer mu.Unlock()
+	db = store
+}
+
+func save(w *Workflow) error {
+	w.Updated = clk.Now()
+	data, err := json.Marshal(w)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if db == nil {
+		return fmt.Errorf("workflow: no database")
+	}
+	return db.Put([]byte(prefix+w.Id), data, &opt.WriteOptions{Sync: true})
+}
+
+// Load returns the workflow with id.
+func Load(id string) (Workflow, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	if db == nil {
+		return Workflow{}, fmt.Errorf("workflow: no database")
+	}
+	data, err := db.Get([]byte(prefix+id), nil)
+	if err != nil {
+		return Workflow{}, fmt.Errorf("workflow %s: %w", id, err)
+	}
+	var w Workflow
+	err = json.Unmarshal(data, &w)
+	return w, err
+}
+
+// List returns every stored workflow, finished ones included.
+func List() ([]Workflow, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	if db == nil {
+		return nil, nil
+	}
+	var workflows []Workflow
+	iter := db.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+	defer iter.Release()
+	for iter.Next() {
+		var w Workflow
+		if err := json.Unmarshal(iter.Value(), &w); err != nil {
+			log.Printf("workflow: %s: %v", iter.Key(), err)
+			continue
+		}
+		workflows = append(workflows, w)
+	}
+	return workflows, iter.Error()
+}
+
+// start stores a new workflow of kind and runs it until ctx is cancelled.
+func start(ctx context.Context, kind string, params map[string]string) (string, error) {
+	now := clk.Now()
+	w := &Workflow{
+		Id:          kind + "-" + uuid.NewString()[:8],
+		Kind:        kind,
+		Params:      params,
+		Status:      Running,
+		StepStarted: now,
+		Created:     now,
+	}
+	if err := save(w); err != nil {
+		return "", err
+	}
+	log.Printf("workflow %s: started %v", w.Id, params)
+	launch(ctx, w)
+	return w.Id, nil
+}
+
+// Resume runs every stored workflow that is still running until ctx is
+// cancelled.
+func Resume(ctx context.Context) error {
+	workflows, err := List()
+	if err != nil {
+		return err
+	}
+	for i := range workflows {
+		w := &workflows[i]
+		if w.Status != Running {
+			if w.Status == Escalated {
+				log.Printf("workflow %s: waiting for an operator at %s: %s", w.Id, w.StepName(), w.Error)
+			}
+			continue
+		}
+		log.Printf("workflow %s: resuming at %s", w.Id, w.StepName())
+		launch(ctx, w)
+	}
+	return nil
+}
+
+// Retry sets an escalated workflow running again from the step it stopped
+// at. It is picked up by the next Resume.
+func Retry(id string) error {
+	return resolve(id, false)
+}
+
+// Skip marks the step an escalated workflow stopped at as done, for when an
+// operator completed it by hand. The workflow is picked up by the next Resume.
+func Skip(id string) error {
+	return resolve(id, true)
+}
+
+func resolve(id string, skip bool) error {
+	w, err := Load(id)
+	if err != nil {
+		return err
+	}
+	if w.Status != Escalated {
+		return fmt.Errorf("workflow %s is %s, not escalated", id, w.Status)
+	}
+	if skip {
+		w.Step++
+	}
+	w.Status, w.Error = Running, ""
+	w.Attempts, w.InFlight, w.StepStarted = 0, false, clk.Now()
+	if w.Step >= len(kinds[w.Kind]) {
+		w.Status = Done
+	}
+	return save(&w)
+}
+
+// run steps through w until it is done, escalated or ctx is cancelled.
+func run(ctx context.Context, w *Workflow) {
+	mu.Lock()
+	if running[w.Id] {
+		mu.Unlock()
+		return
+	}
+	running[w.Id] = true
+	mu.Unlock()
+	defer func() {
+		mu.Lock()
+		delete(running, w.Id)
+		mu.Unlock()
+	}()
+
+	for {
+		wait, wake, stopped := advance(ctx, w)
+		if stopped {
+			return
+		}
+		if wait > 0 {
+			pause(ctx, wait, wake)
+		}
+	}
+}
+
+// advance runs the next step of w once. It returns how long w waits before
+// it runs again, zero to go on at once, with the event that may end the wait
+// early, and whether w stopped: done, escalated or ctx cancelled.
+func advance(ctx context.Context, w *Workflow) (wait time.Duration, wake func(events.Event) bool, stopped bool) {
+	steps, ok := kinds[w.Kind]
+	if !ok {
+		escalate(w, fmt.Errorf("unknown kind %s", w.Kind))
+		return 0, nil, true
+	}
+	if w.Step >= len(steps) {
+		log.Printf("workflow %s: completed", w.Id)
+		return 0, nil, true
+	}
+	if ctx.Err() != nil {
+		return 0, nil, true
+	}
+	s := steps[w.Step]
+	if s.once && w.InFlight {
+		escalate(w, fmt.Errorf("%s was interrupted and may have taken effect; check the exchange, then retry or skip", s.name))
+		return 0, nil, true
+	}
+	if clk.Now().Sub(w.StepStarted) > s.timeout {
+		escalate(w, fmt.Errorf("%s did not complete within %s", s.name, s.timeout))
+		return 0, nil, true
+	}
+
+	if s.once {
+		w.InFlight = true
+		if err := save(w); err != nil {
+			log.Printf("workflow %s: %v", w.Id, err)
+			return 0, nil, true
+		}
+	}
+	done, err := s.run(w)
+	w.InFlight = false
+
+	switch {
+	case errors.As(err, new(*haltError)):
+		escalate(w, fmt.Errorf("%s: %v", s.name, err))
+		return 0, nil, true
+	case err != nil:
+		w.Attempts++
+		log.Printf("workflow %s: %s failed (%d/%d): %v", w.Id, s.name, w.Attempts, maxAttempts, err)
+		if w.Attempts >= maxAttempts {
+			escalate(w, fmt.Errorf("%s failed %d times: %v", s.name, w.Attempts, err))
+			return 0, nil, true
+		}
+		if err := save(w); err != nil {
+			log.Printf("workflow %s: %v", w.Id, err)
+			return 0, nil, true
+		}
+		return retryDelay * time.Duration(w.Attempts), nil, false
+	case done:
+		log.Printf("workflow %s: %s done", w.Id, s.name)
+		w.Step++
+		w.Attempts, w.StepStarted = 0, clk.Now()
+		if w.Step == len(steps) {
+			w.Status = Done
+		}
+		if err := save(w); err != nil {
+			log.Printf("workflow %s: %v", w.Id, err)
+			return 0, nil, true
+		}
+		return 0, nil, false
+	default:
+		if err := save(w); err != nil {
+			log.Printf("workflow %s: %v", w.Id, err)
+			return 0, nil, true
+		}
+		if s.wake != nil {
+			wake = s.wake(w)
+		}
+		return pollInterval, wake, false
+	}
+}
+
+// haltError is returned by a step that must not be retried without an
+// operator looking into it first.
+type haltError struct {
+	err error
+}
+
+func (e *haltError) Error() string { return e.err.Error() }
+func (e *haltError) Unwrap() error { return e.err }
+
+func halt(err error) error {
+	return &haltError{err}
+}
+
+// settle halts on err unless it shows that the request that moved funds did
+// not take effect. After a timeout, a dropped connection or a response that
+// cannot be read the funds may have moved, and repeating the step could move
+// them twice.
+func settle(err error) error {
+	if err == nil || errors.As(err, new(*haltError)) || rest.Rejected(err) {
+		return err
+	}
+	return halt(fmt.Errorf("no clear outcome, check the exchange, then retry or skip: %w", err))
+}
+
+// escalate stops w until an operator retries or skips its step.
+func escalate(w *Workflow, err error) {
+	w.Status, w.Error = Escalated, err.Error()
+	log.Printf("workflow %s: ESCALATED, operator action needed: %v", w.Id, err)
+	if err := save(w); err != nil {
+		log.Printf("workflow %s: %v", w.Id, err)
+	}
+}
+
+// pause waits for d, an event matching wake or ctx to be cancelled.
+func pause(ctx context.Context, d time.Duration, wake func(events.Event) bool) {
+	ch, unsubscribe := events.Subscribe()
+	defer unsubscribe()
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			return
+		case e := <-ch:
+			if wake != nil && wake(e) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"arbitrage/utils"
+	"arbitrage/workflow"
+	"flag"
+	"fmt"
+	"log"
+)
+
+// runWorkflows lists the stored fund movement workflows, or sets an
+// escalated one going again once an operator has looked into it. The trader
+// holds the database, so it must be stopped first; a retried or skipped
+// workflow resumes when it starts again.
+//
+//	arbitrage workflows [-retry id | -skip id]
+func runWorkflows(args []string) {
+	flags := flag.NewFlagSet("workflows", flag.ExitOnError)
+	retry := flags.String("retry", "", "run the step an escalated workflow stopped at again")
+	skip := flags.String("skip", "", "mark the step an escalated workflow stopped at as done by hand")
+	flags.Parse(args)
+
+	db, err := utils.Database("orderdb")
+	if err != nil {
+		log.Fatal("Failed to open LevelDB:", err)
+	}
+	defer db.Close()
+	workflow.Use(db)
+
+	switch {
+	case *retry != "":
+		if err := workflow.Retry(*retry); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(*retry, "will retry its step on the next start")
+	case *skip != "":
+		if err := workflow.Skip(*skip); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(*skip, "will continue after the skipped step on the next start")
+	default:
+		workflows, err := workflow.List()
+		if err != nil {
+			log.Fatal("Failed to list workflows:", err)
+		}
+		for _, w := range workflows {
+			fmt.Printf("%-20s %-10s %-22s %s  %v\n", w.Id, w.Status, w.StepName(), w.Updated.Format("2006-01-02 15:04:05"), w.Params)
+			if w.Error != "" {
+				fmt.Printf("%-20s %s\n", "", w.Error)
+			}
+		}
+	}
+}